@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+// personalAccessTokenTouchInterval limits how often last_used_at is written
+// for a busy token.
+const personalAccessTokenTouchInterval = time.Minute
+
+var errMissingScope = errors.New("token is missing a required scope")
+
+// authenticate identifies the caller from the bearer token, which may be an
+// access token from a login or a personal access token. Access tokens may do
+// anything the user can; personal access tokens must have been granted scope.
+// Endpoints that manage the account itself keep calling auth.ValidateJWT so
+// that a personal access token can't be used there at all.
+func (cfg *apiConfig) authenticate(r *http.Request, scope auth.Scope) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if !auth.IsPersonalAccessToken(token) {
+		return auth.ValidateJWT(token, cfg.keyring)
+	}
+
+	pat, err := cfg.db.GetPersonalAccessTokenByHash(r.Context(), auth.HashToken(token))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	now := time.Now().UTC()
+	if pat.RevokedAt.Valid {
+		return uuid.Nil, errors.New("personal access token revoked")
+	}
+	if pat.ExpiresAt.Valid && pat.ExpiresAt.Time.Before(now) {
+		return uuid.Nil, errors.New("personal access token expired")
+	}
+	if !auth.HasScope(pat.Scopes, scope) {
+		return uuid.Nil, errMissingScope
+	}
+
+	if !pat.LastUsedAt.Valid || now.Sub(pat.LastUsedAt.Time) > personalAccessTokenTouchInterval {
+		err = cfg.db.TouchPersonalAccessToken(r.Context(), database.TouchPersonalAccessTokenParams{
+			ID:         pat.ID,
+			LastUsedAt: sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			log.Printf("Couldn't record use of personal access token %s: %s", pat.ID, err)
+		}
+	}
+
+	return pat.UserID, nil
+}
+
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errMissingScope) {
+		respondWithError(w, http.StatusForbidden, "token is missing a required scope", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+}
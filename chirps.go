@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/entities"
+)
+
+// chirpsFromDB converts database rows into API chirps, embedding the chirp
+// each rechirp or quote refers to. viewerID is the authenticated caller, or
+// uuid.Nil for anonymous requests.
+func (cfg *apiConfig) chirpsFromDB(ctx context.Context, rows []database.Chirp, viewerID uuid.UUID) ([]Chirp, error) {
+	refIDs := []uuid.UUID{}
+	for _, row := range rows {
+		if row.RechirpOf.Valid {
+			refIDs = append(refIDs, row.RechirpOf.UUID)
+		}
+	}
+
+	all := rows
+	if len(refIDs) > 0 {
+		refs, err := cfg.db.ListChirpsByIDs(ctx, refIDs)
+		if err != nil {
+			return nil, err
+		}
+		all = append(append(make([]database.Chirp, 0, len(rows)+len(refs)), rows...), refs...)
+	}
+
+	converted, err := cfg.convertChirps(ctx, all, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]Chirp, len(converted))
+	for _, chirp := range converted[len(rows):] {
+		byID[chirp.ID] = chirp
+	}
+
+	chirps := converted[:len(rows)]
+	for i, row := range rows {
+		if ChirpKind(row.Kind) == ChirpKindChirp {
+			continue
+		}
+		// The original may have been hard-deleted along with its author.
+		ref, ok := byID[row.RechirpOf.UUID]
+		if !row.RechirpOf.Valid || !ok {
+			ref = Chirp{Kind: ChirpKindChirp, Deleted: true}
+		}
+		chirps[i].RechirpOf = &ref
+	}
+
+	return chirps, nil
+}
+
+// convertChirps converts database rows into API chirps, loading the
+// per-chirp aggregates for the whole batch at once.
+func (cfg *apiConfig) convertChirps(ctx context.Context, rows []database.Chirp, viewerID uuid.UUID) ([]Chirp, error) {
+	ids := make([]uuid.UUID, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	replyCounts := make(map[uuid.UUID]int64, len(rows))
+	likeCounts := make(map[uuid.UUID]int64, len(rows))
+	liked := make(map[uuid.UUID]bool, len(rows))
+	mentions := make(map[uuid.UUID][]Mention, len(rows))
+	if len(ids) > 0 {
+		counts, err := cfg.db.CountRepliesByChirpIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, count := range counts {
+			replyCounts[count.InReplyTo.UUID] = count.ReplyCount
+		}
+
+		likes, err := cfg.db.CountLikesByChirpIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, count := range likes {
+			likeCounts[count.ChirpID] = count.LikeCount
+		}
+
+		if viewerID != uuid.Nil {
+			likedIDs, err := cfg.db.ListLikedChirpIDs(ctx, database.ListLikedChirpIDsParams{
+				UserID:   viewerID,
+				ChirpIds: ids,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range likedIDs {
+				liked[id] = true
+			}
+		}
+
+		mentionRows, err := cfg.db.ListMentionsByChirpIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range mentionRows {
+			mentions[row.ChirpID] = append(mentions[row.ChirpID], Mention{
+				UserID: row.UserID,
+				Handle: row.Handle.String,
+				Start:  int(row.StartOffset),
+				End:    int(row.EndOffset),
+			})
+		}
+	}
+
+	chirps := make([]Chirp, 0, len(rows))
+	for _, row := range rows {
+		chirp := Chirp{
+			ID:         row.ID,
+			CreatedAt:  row.CreatedAt,
+			UpdatedAt:  row.UpdatedAt,
+			Body:       row.Body,
+			UserID:     row.UserID,
+			Kind:       ChirpKind(row.Kind),
+			ReplyCount: replyCounts[row.ID],
+			LikeCount:  likeCounts[row.ID],
+			Mentions:   mentions[row.ID],
+		}
+		if chirp.Mentions == nil {
+			chirp.Mentions = []Mention{}
+		}
+		if viewerID != uuid.Nil {
+			likedByMe := liked[row.ID]
+			chirp.LikedByMe = &likedByMe
+		}
+		if row.InReplyTo.Valid {
+			parentID := row.InReplyTo.UUID
+			chirp.InReplyTo = &parentID
+		}
+		if row.DeletedAt.Valid {
+			chirp.Body = ""
+			chirp.Mentions = []Mention{}
+			chirp.Deleted = true
+		}
+		chirps = append(chirps, chirp)
+	}
+
+	return chirps, nil
+}
+
+func (cfg *apiConfig) chirpFromDB(ctx context.Context, row database.Chirp, viewerID uuid.UUID) (Chirp, error) {
+	chirps, err := cfg.chirpsFromDB(ctx, []database.Chirp{row}, viewerID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+// optionalUserID identifies the caller of an endpoint that also serves
+// anonymous requests. It returns uuid.Nil when no bearer token was sent and
+// an error only when a token was sent but is not valid. Personalising the
+// response reveals the caller's likes, so it needs the profile:read scope; a
+// token without it is served the anonymous response.
+func (cfg *apiConfig) optionalUserID(r *http.Request) (uuid.UUID, error) {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.Nil, nil
+	}
+
+	id, err := cfg.authenticate(r, auth.ScopeProfileRead)
+	if errors.Is(err, errMissingScope) {
+		return uuid.Nil, nil
+	}
+	return id, err
+}
+
+// indexEntities replaces the hashtags and mentions recorded for chirp with
+// the ones in its current body. Pass a transactional Queries so the indexes
+// never disagree with the body.
+func indexEntities(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+	err := indexHashtags(ctx, q, chirp)
+	if err != nil {
+		return err
+	}
+	return indexMentions(ctx, q, chirp)
+}
+
+func indexHashtags(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+	err := q.DeleteChirpHashtags(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range entities.Hashtags(chirp.Body) {
+		hashtag, err := q.UpsertHashtag(ctx, database.UpsertHashtagParams{
+			ID:        uuid.New(),
+			Tag:       tag,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.CreateChirpHashtag(ctx, database.CreateChirpHashtagParams{
+			ChirpID:   chirp.ID,
+			HashtagID: hashtag.ID,
+			CreatedAt: chirp.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexMentions resolves the @handles in chirp against users. Handles that
+// don't belong to anyone are left as plain text.
+func indexMentions(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+	err := q.DeleteChirpMentions(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+
+	mentions := entities.Mentions(chirp.Body)
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	handles := make([]string, 0, len(mentions))
+	for _, mention := range mentions {
+		handles = append(handles, strings.ToLower(mention.Handle))
+	}
+
+	users, err := q.GetUsersByHandles(ctx, handles)
+	if err != nil {
+		return err
+	}
+
+	userIDs := make(map[string]uuid.UUID, len(users))
+	for _, user := range users {
+		userIDs[strings.ToLower(user.Handle.String)] = user.ID
+	}
+
+	for _, mention := range mentions {
+		userID, ok := userIDs[strings.ToLower(mention.Handle)]
+		if !ok {
+			continue
+		}
+
+		err = q.CreateChirpMention(ctx, database.CreateChirpMentionParams{
+			ChirpID:     chirp.ID,
+			UserID:      userID,
+			StartOffset: int32(mention.Start),
+			EndOffset:   int32(mention.End),
+			CreatedAt:   chirp.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
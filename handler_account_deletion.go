@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/mail"
+)
+
+// handlerDeleteUser deletes the caller's account. It asks for the password,
+// and a code when 2FA is on, so that a stolen access token alone can't do it.
+// With a grace period the account is only scheduled for deletion and logged
+// out everywhere; logging in again before then keeps it.
+func (cfg *apiConfig) handlerDeleteUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	type response struct {
+		DeleteAfter time.Time `json:"delete_after"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	if !cfg.allowLoginAttempt(w, r, user.Email) {
+		return
+	}
+	err = cfg.passwords.Check(params.Password, user.HashedPassword)
+	if err != nil {
+		if respondIfHasherBusy(w, err) {
+			return
+		}
+		cfg.recordLoginFailure(r, user.Email)
+		respondWithError(w, http.StatusForbidden, "Password is incorrect", err)
+		return
+	}
+
+	if user.TotpEnabled {
+		err = cfg.checkSecondFactor(r.Context(), user, params.Code, params.RecoveryCode, time.Now())
+		if err != nil {
+			if errors.Is(err, errInvalidSecondFactor) {
+				respondWithError(w, http.StatusForbidden, "invalid two-factor code", err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor code", err)
+			return
+		}
+	}
+
+	if cfg.accountDeletionGracePeriod == 0 {
+		err = cfg.db.DeleteUser(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Asking again must not push back a deletion that is already scheduled.
+	deleteAfter := time.Now().UTC().Add(cfg.accountDeletionGracePeriod)
+	if user.DeleteAfter.Valid {
+		deleteAfter = user.DeleteAfter.Time
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	_, err = qtx.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+		ID:          userID,
+		DeleteAfter: sql.NullTime{Time: deleteAfter, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+
+	err = qtx.RevokeAllSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	err = qtx.RevokeAllPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke personal access tokens", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy account will be deleted",
+		Body: fmt.Sprintf("Your Chirpy account and everything you posted will be deleted on %s.\n\n"+
+			"If you change your mind, log in before then and the account will be kept.\n",
+			deleteAfter.Format("2 January 2006 at 15:04 UTC")),
+	})
+
+	respondWithJSON(w, http.StatusAccepted, response{
+		DeleteAfter: deleteAfter,
+	})
+}
+
+// purgeDeletedAccounts deletes the accounts whose grace period has ended.
+// Chirps, tokens, follows and likes go with them through the foreign keys.
+func (cfg *apiConfig) purgeDeletedAccounts(ctx context.Context) {
+	deleted, err := cfg.db.DeleteScheduledUsers(ctx, time.Now().UTC())
+	if err != nil {
+		log.Printf("Couldn't delete scheduled accounts: %s", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d accounts at the end of their grace period", deleted)
+	}
+}
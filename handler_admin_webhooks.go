@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+// requireAdmin checks the ApiKey authorization header against ADMIN_API_KEY.
+// It responds and returns false when the caller isn't an admin.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.adminAPIKey == "" {
+		respondWithError(w, http.StatusForbidden, "Admin API is disabled", nil)
+		return false
+	}
+
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "unable to extract api key", err)
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key), []byte(cfg.adminAPIKey)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "invalid api key", nil)
+		return false
+	}
+	return true
+}
+
+// handlerGetWebhookEvents lists the latest stored webhook events, optionally
+// only those with the given ?status=.
+func (cfg *apiConfig) handlerGetWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	params := database.ListWebhookEventsParams{Limit: defaultPageLimit}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxPageLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageLimit), err)
+			return
+		}
+		params.Limit = int32(n)
+	}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		switch status {
+		case webhookStatusPending, webhookStatusProcessed, webhookStatusIgnored, webhookStatusFailed:
+		default:
+			respondWithError(w, http.StatusBadRequest, "Unknown status", nil)
+			return
+		}
+		params.Status = sql.NullString{String: status, Valid: true}
+	}
+
+	rows, err := cfg.db.ListWebhookEvents(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch events", err)
+		return
+	}
+
+	events := make([]WebhookEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, webhookEventFromDB(row))
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+func (cfg *apiConfig) handlerGetWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	event, err := cfg.db.GetWebhookEvent(r.Context(), r.PathValue("eventID"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find event with the provided id", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch event", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookEventFromDB(event))
+}
+
+// handlerReplayWebhookEvent runs a stored event again, even one that was
+// already processed, and returns it with the new outcome.
+func (cfg *apiConfig) handlerReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	event, err := cfg.processPolkaEvent(r.Context(), r.PathValue("eventID"), true)
+	if err != nil && !polkaEventFailed(err) {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find event with the provided id", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't replay event", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookEventFromDB(event))
+}
+
+func webhookEventFromDB(event database.WebhookEvent) WebhookEvent {
+	e := WebhookEvent{
+		ID:         event.ID,
+		Event:      event.Event,
+		Status:     event.Status,
+		Attempts:   event.Attempts,
+		LastError:  event.LastError.String,
+		ReceivedAt: event.ReceivedAt,
+		Payload:    event.Payload,
+	}
+	if event.ProcessedAt.Valid {
+		processedAt := event.ProcessedAt.Time
+		e.ProcessedAt = &processedAt
+	}
+	return e
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// The revision is taken from the body read here, so it has to be the
+	// latest one.
+	chirp, err := qtx.LockChirp(r.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+		return
+	}
+
+	if chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", nil)
+		return
+	}
+
+	if userID != chirp.UserID {
+		respondWithError(w, http.StatusForbidden, "Not allowed to edit chirp of another user", nil)
+		return
+	}
+
+	if ChirpKind(chirp.Kind) == ChirpKindRechirp {
+		respondWithError(w, http.StatusBadRequest, "A rechirp has no body to edit", nil)
+		return
+	}
+
+	ent, err := cfg.entitlementsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch entitlements", err)
+		return
+	}
+
+	if !ent.ChirpEditing {
+		respondWithError(w, http.StatusForbidden, "Your plan doesn't allow editing chirps", nil)
+		return
+	}
+
+	if cfg.chirpEditWindow > 0 && time.Since(chirp.CreatedAt) > cfg.chirpEditWindow {
+		respondWithError(w, http.StatusForbidden, "Chirp can no longer be edited", nil)
+		return
+	}
+
+	if len(params.Body) > ent.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+		ID:         uuid.New(),
+		ChirpID:    chirp.ID,
+		Body:       chirp.Body,
+		CreatedAt:  chirp.UpdatedAt,
+		ReplacedAt: now,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chirp revision", err)
+		return
+	}
+
+	result, err := qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:        chirp.ID,
+		Body:      getCleanedBody(params.Body),
+		UpdatedAt: now,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	err = indexEntities(r.Context(), qtx, result)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't index hashtags and mentions", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	updated, err := cfg.chirpFromDB(r.Context(), result, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (cfg *apiConfig) handlerGetChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	chirp, err := cfg.db.GetChirpByID(r.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+		return
+	}
+
+	if chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", nil)
+		return
+	}
+
+	result, err := cfg.db.ListChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp revisions", err)
+		return
+	}
+
+	revisions := make([]ChirpRevision, 0, len(result))
+	for _, row := range result {
+		revisions = append(revisions, ChirpRevision{
+			ID:         row.ID,
+			Body:       row.Body,
+			CreatedAt:  row.CreatedAt,
+			ReplacedAt: row.ReplacedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, revisions)
+}
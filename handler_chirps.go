@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,21 +17,33 @@ import (
 	"github.com/trungdoanle1101/chirp/internal/database"
 )
 
+// chirpRateWindow is the period entitlements.Entitlements.ChirpsPerHour
+// applies to.
+const chirpRateWindow = time.Hour
+
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string     `json:"body"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
+		RechirpOf *uuid.UUID `json:"rechirp_of"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	id, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		respondWithAuthError(w, err)
 		return
 	}
 
-	id, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
-		return
+	if cfg.requireVerifiedEmail {
+		user, err := cfg.db.GetUserByID(r.Context(), id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+			return
+		}
+		if !user.EmailVerifiedAt.Valid {
+			respondWithError(w, http.StatusForbidden, "Verify your email address before posting", nil)
+			return
+		}
 	}
 
 	params := parameters{}
@@ -36,14 +51,100 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 	err = decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
 	}
 
-	const maxChirpLength = 140
-	if len(params.Body) > maxChirpLength {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long", err)
+	ent, err := cfg.entitlementsFor(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch entitlements", err)
 		return
 	}
 
+	if len(params.Body) > ent.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+		return
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chip", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// The chirps being replied to or rechirped stay share-locked until the
+	// new chirp is committed, so deleting them waits and then sees it.
+	inReplyTo := uuid.NullUUID{}
+	if params.InReplyTo != nil {
+		parent, err := qtx.ShareLockChirp(r.Context(), *params.InReplyTo)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "Couldn't find the chirp being replied to", err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+			return
+		}
+		if parent.DeletedAt.Valid {
+			respondWithError(w, http.StatusBadRequest, "Can't reply to a deleted chirp", nil)
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+
+	// A rechirp_of without a body is a plain rechirp, with a body it is a quote.
+	kind := ChirpKindChirp
+	rechirpOf := uuid.NullUUID{}
+	if params.RechirpOf != nil {
+		original, err := qtx.ShareLockChirp(r.Context(), *params.RechirpOf)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusNotFound, "Couldn't find the chirp being rechirped", err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+			return
+		}
+
+		// Rechirping a plain rechirp amplifies the chirp it points at.
+		if ChirpKind(original.Kind) == ChirpKindRechirp && original.RechirpOf.Valid {
+			original, err = qtx.ShareLockChirp(r.Context(), original.RechirpOf.UUID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+				return
+			}
+		}
+
+		if original.DeletedAt.Valid {
+			respondWithError(w, http.StatusBadRequest, "Can't rechirp a deleted chirp", nil)
+			return
+		}
+
+		kind = ChirpKindQuote
+		if params.Body == "" {
+			if inReplyTo.Valid {
+				respondWithError(w, http.StatusBadRequest, "A rechirp can't be a reply", nil)
+				return
+			}
+
+			hasRechirped, err := qtx.UserHasRechirped(r.Context(), database.UserHasRechirpedParams{
+				UserID:  id,
+				ChirpID: original.ID,
+			})
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+				return
+			}
+			if hasRechirped {
+				respondWithError(w, http.StatusConflict, "Chirp already rechirped", nil)
+				return
+			}
+			kind = ChirpKindRechirp
+		}
+		rechirpOf = uuid.NullUUID{UUID: original.ID, Valid: true}
+	}
+
 	cleaned := getCleanedBody(params.Body)
 
 	ccParams := database.CreateChirpParams{
@@ -52,19 +153,58 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 		UpdatedAt: time.Now().UTC(),
 		Body:      cleaned,
 		UserID:    id,
+		InReplyTo: inReplyTo,
+		Kind:      string(kind),
+		RechirpOf: rechirpOf,
+	}
+
+	// Concurrent posts by the same user wait here, so each one counts the
+	// chirps the others created.
+	err = qtx.LockUser(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+		return
+	}
+
+	since := time.Now().UTC().Add(-chirpRateWindow)
+	posted, err := qtx.CountChirpsByUserSince(r.Context(), database.CountChirpsByUserSinceParams{
+		Since:  since,
+		UserID: id,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
+		return
+	}
+	if posted.ChirpCount >= int64(ent.ChirpsPerHour) {
+		// Room frees up when the oldest counted chirp leaves the window.
+		wait := posted.OldestCreatedAt.Sub(since)
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+		respondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("You can post at most %d chirps an hour", ent.ChirpsPerHour), nil)
+		return
 	}
 
-	result, err := cfg.db.CreateChirp(r.Context(), ccParams)
+	result, err := qtx.CreateChirp(r.Context(), ccParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chip", err)
+		return
 	}
 
-	chirp := Chirp{
-		ID:        result.ID,
-		CreatedAt: result.CreatedAt,
-		UpdatedAt: result.UpdatedAt,
-		Body:      result.Body,
-		UserID:    result.UserID,
+	err = indexEntities(r.Context(), qtx, result)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't index hashtags and mentions", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chip", err)
+		return
+	}
+
+	chirp, err := cfg.chirpFromDB(r.Context(), result, id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chip", err)
+		return
 	}
 
 	respondWithJSON(w, http.StatusCreated, chirp)
@@ -72,24 +212,20 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
-	result, err := cfg.db.GetChirps(r.Context())
+	viewerID, err := cfg.optionalUserID(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		respondWithAuthError(w, err)
 		return
 	}
 
-	authorID := uuid.Nil
+	authorID := uuid.NullUUID{}
 	if authorIDStr := r.URL.Query().Get("author_id"); authorIDStr != "" {
-		authorID, err = uuid.Parse(authorIDStr)
+		id, err := uuid.Parse(authorIDStr)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid author_id", err)
 			return
 		}
-		result, err = cfg.db.GetChirpsByUserID(r.Context(), authorID)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
-			return
-		}
+		authorID = uuid.NullUUID{UUID: id, Valid: true}
 	}
 
 	sortOrder := r.URL.Query().Get("sort")
@@ -100,32 +236,106 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	limit, cursor, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
 
-	chirps := make([]Chirp, 0, len(result))
-	for _, row := range result {
-		if authorID != uuid.Nil && row.UserID != authorID {
-			continue
-		}
-		chirps = append(chirps, Chirp{
-			ID:        row.ID,
-			CreatedAt: row.CreatedAt,
-			UpdatedAt: row.UpdatedAt,
-			Body:      row.Body,
-			UserID:    row.UserID,
+	// Fetch one extra row so we know whether another page follows.
+	var result []database.Chirp
+	if sortOrder == "desc" {
+		result, err = cfg.db.ListChirpsDesc(r.Context(), database.ListChirpsDescParams{
+			AuthorID:        authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           int32(limit + 1),
+		})
+	} else {
+		result, err = cfg.db.ListChirpsAsc(r.Context(), database.ListChirpsAscParams{
+			AuthorID:        authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           int32(limit + 1),
 		})
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		return
+	}
 
-	sort.Slice(chirps, func(i, j int) bool {
-		if sortOrder == "desc" {
-			return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
-		}
-		return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
+	hasMore := len(result) > limit
+	if hasMore {
+		result = result[:limit]
+	}
+
+	chirps, err := cfg.chirpsFromDB(r.Context(), result, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		return
+	}
+
+	if hasMore {
+		last := result[len(result)-1]
+		setNextPageLink(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+func (cfg *apiConfig) handlerGetTimeline(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeProfileRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	result, err := cfg.db.ListTimelineChirps(r.Context(), database.ListTimelineChirpsParams{
+		UserID:          userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(limit + 1),
 	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch timeline", err)
+		return
+	}
+
+	hasMore := len(result) > limit
+	if hasMore {
+		result = result[:limit]
+	}
+
+	chirps, err := cfg.chirpsFromDB(r.Context(), result, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		return
+	}
+
+	if hasMore {
+		last := result[len(result)-1]
+		setNextPageLink(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
 
 	respondWithJSON(w, http.StatusOK, chirps)
 }
 
 func (cfg *apiConfig) handlerGetChirpByID(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
 	chirpID := r.PathValue("chirpID")
 	if chirpID == "" {
 		respondWithError(w, http.StatusBadRequest, "invalid chirpID", nil)
@@ -148,12 +358,15 @@ func (cfg *apiConfig) handlerGetChirpByID(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	chirp := Chirp{
-		ID:        result.ID,
-		CreatedAt: result.CreatedAt,
-		UpdatedAt: result.UpdatedAt,
-		Body:      result.Body,
-		UserID:    result.UserID,
+	if result.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", nil)
+		return
+	}
+
+	chirp, err := cfg.chirpFromDB(r.Context(), result, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+		return
 	}
 
 	respondWithJSON(w, http.StatusOK, chirp)
@@ -161,15 +374,9 @@ func (cfg *apiConfig) handlerGetChirpByID(w http.ResponseWriter, r *http.Request
 }
 
 func (cfg *apiConfig) handlerDeleteChirpByID(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := cfg.authenticate(r, auth.ScopeChirpsDelete)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		respondWithAuthError(w, err)
 		return
 	}
 
@@ -185,7 +392,17 @@ func (cfg *apiConfig) handlerDeleteChirpByID(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	chirp, err := cfg.db.GetChirpByID(r.Context(), chirpID)
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// Replies and rechirps share-lock the chirp they point at, so once this
+	// lock is held none can be added before the delete commits.
+	chirp, err := qtx.LockChirp(r.Context(), chirpID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", err)
@@ -195,12 +412,37 @@ func (cfg *apiConfig) handlerDeleteChirpByID(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", nil)
+		return
+	}
+
 	if userID.String() != chirp.UserID.String() {
 		respondWithError(w, http.StatusForbidden, "Not allowed to delete chirp of another user", nil)
 		return
 	}
 
-	err = cfg.db.DeleteChirpByID(r.Context(), chirpID)
+	// A chirp that has replies or is rechirped is replaced by a tombstone so
+	// the chirps pointing at it keep their shape. So is one that still counts
+	// towards the hourly limit, or deleting and reposting would get around
+	// it.
+	isReferenced, err := qtx.ChirpIsReferenced(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+
+	if isReferenced || chirp.CreatedAt.After(time.Now().UTC().Add(-chirpRateWindow)) {
+		err = tombstoneChirp(r.Context(), qtx, chirpID)
+	} else {
+		err = qtx.DeleteChirpByID(r.Context(), chirpID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
 		return
@@ -209,6 +451,25 @@ func (cfg *apiConfig) handlerDeleteChirpByID(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// tombstoneChirp clears a chirp's body and everything derived from it. Pass
+// a transactional Queries so a failure can't leave a tombstone that still
+// has its revisions or index entries.
+func tombstoneChirp(ctx context.Context, q *database.Queries, chirpID uuid.UUID) error {
+	err := q.DeleteChirpRevisions(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+	err = q.DeleteChirpHashtags(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+	err = q.DeleteChirpMentions(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+	return q.TombstoneChirp(ctx, chirpID)
+}
+
 func getCleanedBody(body string) string {
 	badWords := map[string]struct{}{
 		"kerfuffle": {},
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/mail"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+// validEmail reports whether email is a bare address such as
+// alice@example.com, without a display name.
+func validEmail(email string) bool {
+	addr, err := netmail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// errTooManyEmails is returned by sendVerificationEmail when the address was
+// sent too many emails recently.
+var errTooManyEmails = errors.New("too many emails sent to this address")
+
+// sendVerificationEmail mails userID a link that proves they own email,
+// which is either their current address or the one they are changing to.
+// Every send counts against the address in cfg.emailLimiter, so no path can
+// be used to flood someone's inbox.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	key := loginAccountKey(email)
+	if _, ok := cfg.emailLimiter.Allow(key); !ok {
+		return errTooManyEmails
+	}
+	cfg.emailLimiter.Failure(key)
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = cfg.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Email:     email,
+		TokenHash: auth.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.appURL + "/app/verify-email?token=" + url.QueryEscape(token)
+	cfg.sendMail(mail.Message{
+		To:      email,
+		Subject: "Confirm your email address for Chirpy",
+		Body: fmt.Sprintf("Open this link within %d hours to confirm that %s is your email address:\n\n%s\n\n"+
+			"If you didn't sign up for Chirpy or change your email, you can ignore this email.\n",
+			int(emailVerificationTTL.Hours()), email, link),
+	})
+	return nil
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	verification, err := cfg.db.GetEmailVerificationTokenByHash(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired verification token", err)
+		return
+	}
+
+	if verification.UsedAt.Valid || verification.ExpiresAt.Before(time.Now().UTC()) {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired verification token", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), verification.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	used, err := qtx.UseEmailVerificationToken(r.Context(), verification.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	if used == 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired verification token", nil)
+		return
+	}
+
+	verifiedAt := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	if verification.Email == user.Email {
+		user, err = qtx.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+			EmailVerifiedAt: verifiedAt,
+			ID:              user.ID,
+			Email:           verification.Email,
+		})
+	} else {
+		user, err = qtx.ConfirmPendingEmail(r.Context(), database.ConfirmPendingEmailParams{
+			EmailVerifiedAt: verifiedAt,
+			ID:              user.ID,
+			PendingEmail:    verification.Email,
+		})
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusBadRequest, "invalid or expired verification token", err)
+			return
+		}
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Email already taken", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	isChirpyRed, err := cfg.isChirpyRed(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch subscription", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user, isChirpyRed))
+}
+
+// handlerResendVerificationEmail sends a fresh link for the pending email if
+// there is one, or else for the current email if it isn't verified yet.
+func (cfg *apiConfig) handlerResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	email := user.PendingEmail.String
+	if !user.PendingEmail.Valid {
+		if user.EmailVerifiedAt.Valid {
+			respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+			return
+		}
+		email = user.Email
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), user.ID, email)
+	if errors.Is(err, errTooManyEmails) {
+		respondWithError(w, http.StatusTooManyRequests, "too many emails sent, try again later", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
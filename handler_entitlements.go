@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/trungdoanle1101/chirp/internal/auth"
+)
+
+// handlerGetEntitlements tells clients what the caller's plan lets them do,
+// so they can adapt their UI instead of waiting for a request to be refused.
+func (cfg *apiConfig) handlerGetEntitlements(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeProfileRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	ent, err := cfg.entitlementsFor(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch entitlements", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ent)
+}
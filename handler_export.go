@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/trungdoanle1101/chirp/internal/auth"
+)
+
+// accountExport is everything handed over for a data-portability request.
+type accountExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Profile    User      `json:"profile"`
+	Chirps     []Chirp   `json:"chirps"`
+	Sessions   []Session `json:"sessions"`
+}
+
+// handlerExportUser returns the caller's profile, chirps and sessions. By
+// default they come as a ZIP archive with one JSON file each; ?format=json
+// returns a single JSON document instead.
+func (cfg *apiConfig) handlerExportUser(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		respondWithError(w, http.StatusBadRequest, "format must be zip or json", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	rows, err := cfg.db.GetChirpsByUserID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		return
+	}
+
+	chirps, err := cfg.chirpsFromDB(r.Context(), rows, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		return
+	}
+
+	sessions, err := cfg.db.ListSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch sessions", err)
+		return
+	}
+
+	isChirpyRed, err := cfg.isChirpyRed(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch subscription", err)
+		return
+	}
+
+	export := accountExport{
+		ExportedAt: time.Now().UTC(),
+		Profile:    userFromDB(user, isChirpyRed),
+		Chirps:     chirps,
+		Sessions:   sessionsFromDB(sessions),
+	}
+
+	filename := fmt.Sprintf("chirpy-export-%s", export.ExportedAt.Format("2006-01-02"))
+	if format == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		respondWithJSON(w, http.StatusOK, export)
+		return
+	}
+
+	// Build the archive in memory so a failure can still be reported with a
+	// proper status code.
+	archive, err := zipExport(export)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build export", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+func zipExport(export accountExport) ([]byte, error) {
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"chirps.json", export.Chirps},
+		{"sessions.json", export.Sessions},
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, file := range files {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
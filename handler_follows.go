@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+func (cfg *apiConfig) handlerFollowUser(w http.ResponseWriter, r *http.Request) {
+	followerID, err := cfg.authenticate(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	if followerID == followeeID {
+		respondWithError(w, http.StatusBadRequest, "Users can't follow themselves", nil)
+		return
+	}
+
+	_, err = cfg.db.GetUserByID(r.Context(), followeeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user with the provided id", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	err = cfg.db.CreateFollow(r.Context(), database.CreateFollowParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followerID, err := cfg.authenticate(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	err = cfg.db.DeleteFollow(r.Context(), database.DeleteFollowParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	result, err := cfg.db.ListFollowers(r.Context(), database.ListFollowersParams{
+		UserID:          userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch followers", err)
+		return
+	}
+
+	follows := make([]Follow, 0, len(result))
+	for _, row := range result {
+		follows = append(follows, Follow{UserID: row.UserID, CreatedAt: row.CreatedAt})
+	}
+
+	respondWithFollowPage(w, r, follows, limit)
+}
+
+func (cfg *apiConfig) handlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	result, err := cfg.db.ListFollowing(r.Context(), database.ListFollowingParams{
+		UserID:          userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch followed users", err)
+		return
+	}
+
+	follows := make([]Follow, 0, len(result))
+	for _, row := range result {
+		follows = append(follows, Follow{UserID: row.UserID, CreatedAt: row.CreatedAt})
+	}
+
+	respondWithFollowPage(w, r, follows, limit)
+}
+
+// respondWithFollowPage trims the extra look-ahead row fetched by the list
+// handlers and sets the Link header when another page exists.
+func respondWithFollowPage(w http.ResponseWriter, r *http.Request, follows []Follow, limit int) {
+	if len(follows) > limit {
+		follows = follows[:limit]
+		last := follows[len(follows)-1]
+		setNextPageLink(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.UserID})
+	}
+
+	respondWithJSON(w, http.StatusOK, follows)
+}
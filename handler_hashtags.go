@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+	maxTrendingLimit      = 50
+)
+
+func (cfg *apiConfig) handlerGetHashtagChirps(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	tag := strings.ToLower(strings.TrimPrefix(r.PathValue("tag"), "#"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "invalid tag", nil)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	result, err := cfg.db.ListChirpsByHashtag(r.Context(), database.ListChirpsByHashtagParams{
+		Tag:             tag,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		return
+	}
+
+	hasMore := len(result) > limit
+	if hasMore {
+		result = result[:limit]
+	}
+
+	chirps, err := cfg.chirpsFromDB(r.Context(), result, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirps", err)
+		return
+	}
+
+	if hasMore {
+		last := result[len(result)-1]
+		setNextPageLink(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+func (cfg *apiConfig) handlerGetTrendingHashtags(w http.ResponseWriter, r *http.Request) {
+	window := defaultTrendingWindow
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		d, err := time.ParseDuration(windowStr)
+		if err != nil || d <= 0 || d > maxTrendingWindow {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("window must be a duration up to %s", maxTrendingWindow), err)
+			return
+		}
+		window = d
+	}
+
+	limit := defaultTrendingLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxTrendingLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxTrendingLimit), err)
+			return
+		}
+		limit = n
+	}
+
+	result, err := cfg.db.ListTrendingHashtags(r.Context(), database.ListTrendingHashtagsParams{
+		Since: time.Now().UTC().Add(-window),
+		Limit: int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch trending hashtags", err)
+		return
+	}
+
+	hashtags := make([]TrendingHashtag, 0, len(result))
+	for _, row := range result {
+		hashtags = append(hashtags, TrendingHashtag{
+			Tag:        row.Tag,
+			ChirpCount: row.ChirpCount,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, hashtags)
+}
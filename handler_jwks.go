@@ -0,0 +1,10 @@
+package main
+
+import "net/http"
+
+// handlerJWKS publishes the public keys that verify our access tokens so
+// other services don't need to call us to check one.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.keyring.JWKS())
+}
@@ -2,23 +2,55 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/trungdoanle1101/chirp/internal/auth"
 	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/lockout"
 )
 
+const twoFactorChallengeTTL = 5 * time.Minute
+
+// loginAccountPolicy slows down guessing one account's password. Anyone who
+// knows an email address can fail logins for it, so there is no lockout and
+// the delay stays short: failing on purpose only slows the owner down.
+// Guessing from many addresses is left to loginIPPolicy and, for accounts
+// that have it, the second factor.
+var loginAccountPolicy = lockout.Policy{
+	FreeAttempts: 5,
+	BaseDelay:    time.Second,
+	MaxDelay:     30 * time.Second,
+	ResetAfter:   time.Hour,
+}
+
+// loginIPPolicy slows down one client trying a few passwords against many
+// accounts. It is looser than loginAccountPolicy because many users can
+// share an address.
+var loginIPPolicy = lockout.Policy{
+	FreeAttempts:     20,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	LockoutThreshold: 100,
+	LockoutDuration:  time.Hour,
+	ResetAfter:       time.Hour,
+}
+
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
-	type response struct {
-		User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+	type challengeResponse struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
 	}
 
 	params := parameters{}
@@ -29,56 +61,156 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !cfg.allowLoginAttempt(w, r, params.Email) {
+		return
+	}
+
 	result, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
+		if respondIfHasherBusy(w, cfg.passwords.CheckDummy(params.Password)) {
+			return
+		}
+		cfg.recordLoginFailure(r, params.Email)
 		respondWithError(w, http.StatusUnauthorized, "incorrect email or password", err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, result.HashedPassword)
+	err = cfg.passwords.Check(params.Password, result.HashedPassword)
 	if err != nil {
+		if respondIfHasherBusy(w, err) {
+			return
+		}
+		cfg.recordLoginFailure(r, params.Email)
 		respondWithError(w, http.StatusUnauthorized, "incorrect email or password", err)
 		return
 	}
 
+	// This is the only time the plain password is at hand, so use it to
+	// bring bcrypt hashes and hashes with outdated parameters up to date.
+	if cfg.passwords.NeedsRehash(result.HashedPassword) {
+		cfg.rehashPassword(r, result.ID, params.Password)
+	}
+
+	// With 2FA on, the password alone only earns a challenge token that has
+	// to be exchanged at /api/login/2fa together with a code.
+	if result.TotpEnabled {
+		challenge, err := auth.MakeChallengeJWT(result.ID, cfg.keyring, twoFactorChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "unable to generate token", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, challengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
+		return
+	}
+
+	cfg.loginAccountLimiter.Success(loginAccountKey(result.Email))
+	cfg.respondWithLogin(w, r, result)
+}
+
+// allowLoginAttempt responds with 429 and returns false when either the
+// account or the client's address has failed too often recently.
+func (cfg *apiConfig) allowLoginAttempt(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait, ok := cfg.loginAccountLimiter.Allow(loginAccountKey(email))
+	if ok {
+		wait, ok = cfg.loginIPLimiter.Allow(clientIP(r))
+	}
+	if ok {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "too many failed login attempts, try again later", nil)
+	return false
+}
+
+// recordLoginFailure counts a failed attempt against both the account and
+// the client's address. The address is not reset by a successful login, so
+// an attacker can't clear it by logging into an account of their own.
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, email string) {
+	cfg.loginAccountLimiter.Failure(loginAccountKey(email))
+	cfg.loginIPLimiter.Failure(clientIP(r))
+}
+
+// respondIfHasherBusy responds with 503 and returns true when err is
+// auth.ErrHasherBusy. A busy hasher says nothing about the password, so it
+// must not count as a failed attempt.
+func respondIfHasherBusy(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, auth.ErrHasherBusy) {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	respondWithError(w, http.StatusServiceUnavailable, "Server is busy, try again later", err)
+	return true
+}
+
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// respondWithLogin issues an access token and starts a new session for a
+// user who has passed every login step.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// Logging in during the deletion grace period keeps the account.
+	if user.DeleteAfter.Valid {
+		var err error
+		user, err = cfg.db.CancelUserDeletion(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't cancel account deletion", err)
+			return
+		}
+	}
+
 	expirationTime := time.Hour
 
-	accessToken, err := auth.MakeJWT(result.ID, cfg.jwtSecret, expirationTime)
+	accessToken, err := auth.MakeJWT(user.ID, cfg.keyring, expirationTime)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "unable to generate token", err)
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	refreshToken, err := createRefreshToken(r, cfg.db, user.ID, uuid.New())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "unable to generate refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "unable to save refresh token", err)
 		return
 	}
 
-	refreshTokenParams := database.CreateRefreshTokenParams{
-		Token:     refreshToken,
-		CreatedAt: time.Now().UTC(),
-		UpdatedAt: time.Now().UTC(),
-		UserID:    result.ID,
-		ExpiresAt: time.Now().UTC().Add(60 * 24 * time.Hour),
-	}
-
-	_, err = cfg.db.CreateRefreshToken(r.Context(), refreshTokenParams)
+	isChirpyRed, err := cfg.isChirpyRed(r.Context(), user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "unable to save refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch subscription", err)
+		return
 	}
 
 	resp := response{
-
-		User: User{
-			ID:        result.ID,
-			CreatedAt: result.CreatedAt,
-			UpdatedAt: result.UpdatedAt,
-			Email:     result.Email,
-			IsChirpyRed: result.IsChirpyRed,
-		},
+		User:         userFromDB(user, isChirpyRed),
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 }
+
+// rehashPassword stores a fresh hash of password for the user. A failure is
+// only logged: the login itself already succeeded and the next one will try
+// again.
+func (cfg *apiConfig) rehashPassword(r *http.Request, userID uuid.UUID, password string) {
+	hashedPassword, err := cfg.passwords.Hash(password)
+	if err != nil {
+		log.Printf("Couldn't rehash password for user %s: %s", userID, err)
+		return
+	}
+	err = cfg.db.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             userID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		log.Printf("Couldn't store rehashed password for user %s: %s", userID, err)
+	}
+}
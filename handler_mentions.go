@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+func (cfg *apiConfig) handlerGetMentions(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticate(r, auth.ScopeProfileRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+	result, err := cfg.db.ListChirpsMentioningUser(r.Context(), database.ListChirpsMentioningUserParams{
+		UserID:          userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch mentions", err)
+		return
+	}
+
+	hasMore := len(result) > limit
+	if hasMore {
+		result = result[:limit]
+	}
+
+	chirps, err := cfg.chirpsFromDB(r.Context(), result, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch mentions", err)
+		return
+	}
+
+	if hasMore {
+		last := result[len(result)-1]
+		setNextPageLink(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
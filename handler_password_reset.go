@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/lockout"
+	"github.com/trungdoanle1101/chirp/internal/mail"
+)
+
+const passwordResetTTL = time.Hour
+
+// emailPolicy limits how many emails one address can be sent, so the
+// endpoints that send them can't be used to flood someone's inbox.
+var emailPolicy = lockout.Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Minute,
+	MaxDelay:     time.Hour,
+	ResetAfter:   24 * time.Hour,
+}
+
+// handlerRequestPasswordReset emails a reset link. It answers the same way
+// whether or not the address belongs to an account, so it can't be used to
+// find out who has signed up.
+func (cfg *apiConfig) handlerRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	key := loginAccountKey(params.Email)
+	if _, ok := cfg.emailLimiter.Allow(key); !ok {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	cfg.emailLimiter.Failure(key)
+
+	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Couldn't look up user for password reset: %s", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	err = cfg.db.CreatePasswordResetToken(r.Context(), database.CreatePasswordResetTokenParams{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token", err)
+		return
+	}
+
+	link := cfg.appURL + "/app/reset-password?token=" + url.QueryEscape(token)
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"To choose a new password, open this link within %d minutes:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", int(passwordResetTTL.Minutes()), link),
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerConfirmPasswordReset sets a new password from a reset link and logs
+// the account out everywhere.
+func (cfg *apiConfig) handlerConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+
+	resetToken, err := cfg.db.GetPasswordResetTokenByHash(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired reset token", err)
+		return
+	}
+
+	if resetToken.UsedAt.Valid || resetToken.ExpiresAt.Before(time.Now().UTC()) {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired reset token", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	err = auth.CheckPasswordStrength(params.Password, user.Email, user.Handle.String)
+	if err != nil {
+		respondWithValidationErrors(w, map[string]string{"password": "Password " + err.Error()})
+		return
+	}
+
+	hashedPassword, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		if respondIfHasherBusy(w, err) {
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	used, err := qtx.UsePasswordResetTokens(r.Context(), database.UsePasswordResetTokensParams{
+		UserID: resetToken.UserID,
+		ID:     resetToken.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	if used == 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid or expired reset token", nil)
+		return
+	}
+
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             resetToken.UserID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	err = qtx.RevokeAllSessions(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	cfg.loginAccountLimiter.Success(loginAccountKey(user.Email))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendMail delivers msg in the background. Waiting for the mail server would
+// slow down only the requests that send mail, which would give away, for
+// example, whether a password reset was requested for a real account.
+func (cfg *apiConfig) sendMail(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		err := cfg.mailer.Send(ctx, msg)
+		if err != nil {
+			log.Printf("Couldn't send %q to %s: %s", msg.Subject, msg.To, err)
+		}
+	}()
+}
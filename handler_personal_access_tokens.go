@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+const (
+	maxPersonalAccessTokenName = 100
+	maxPersonalAccessTokenDays = 365
+)
+
+// Personal access tokens are managed with an access token from a login only,
+// so a leaked personal access token can't be used to mint more.
+
+func (cfg *apiConfig) handlerCreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Name == "" || len(params.Name) > maxPersonalAccessTokenName {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Name must be between 1 and %d characters", maxPersonalAccessTokenName), nil)
+		return
+	}
+
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	scopes := make([]string, 0, len(params.Scopes))
+	seen := make(map[string]bool, len(params.Scopes))
+	for _, scope := range params.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown scope %q", scope), nil)
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	// Zero means the token doesn't expire.
+	if params.ExpiresInDays < 0 || params.ExpiresInDays > maxPersonalAccessTokenDays {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_days must be between 0 and %d", maxPersonalAccessTokenDays), nil)
+		return
+	}
+
+	secret, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create personal access token", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: now.AddDate(0, 0, params.ExpiresInDays), Valid: true}
+	}
+
+	result, err := cfg.db.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      params.Name,
+		TokenHash: auth.HashToken(secret),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create personal access token", err)
+		return
+	}
+
+	pat := personalAccessTokenFromDB(result)
+	pat.Token = secret
+	respondWithJSON(w, http.StatusCreated, pat)
+}
+
+func (cfg *apiConfig) handlerGetPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	result, err := cfg.db.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch personal access tokens", err)
+		return
+	}
+
+	tokens := make([]PersonalAccessToken, 0, len(result))
+	for _, row := range result {
+		tokens = append(tokens, personalAccessTokenFromDB(row))
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+func (cfg *apiConfig) handlerRevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke personal access token", err)
+		return
+	}
+
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find personal access token with the provided id", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func personalAccessTokenFromDB(row database.PersonalAccessToken) PersonalAccessToken {
+	pat := PersonalAccessToken{
+		ID:        row.ID,
+		Name:      row.Name,
+		Scopes:    row.Scopes,
+		CreatedAt: row.CreatedAt,
+	}
+	if row.ExpiresAt.Valid {
+		expiresAt := row.ExpiresAt.Time
+		pat.ExpiresAt = &expiresAt
+	}
+	if row.LastUsedAt.Valid {
+		lastUsedAt := row.LastUsedAt.Time
+		pat.LastUsedAt = &lastUsedAt
+	}
+	return pat
+}
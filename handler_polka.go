@@ -1,66 +1,275 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/entitlements"
+	"github.com/trungdoanle1101/chirp/internal/polka"
 )
 
 type Event string
 
-const EventUserUpgraded Event = "user.upgraded"
+const (
+	EventUserUpgraded        Event = "user.upgraded"
+	EventUserDowngraded      Event = "user.downgraded"
+	EventSubscriptionRenewed Event = "subscription.renewed"
+	EventSubscriptionExpired Event = "subscription.expired"
+)
 
-func (cfg *apiConfig) handlerPolkaWebhooks(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
-	}
+// Values of webhook_events.status.
+const (
+	webhookStatusPending   = "pending"
+	webhookStatusProcessed = "processed"
+	webhookStatusIgnored   = "ignored"
+	webhookStatusFailed    = "failed"
+)
+
+// maxWebhookBodyBytes is far more than any Polka event needs.
+const maxWebhookBodyBytes = 64 << 10
+
+// polkaEvent is the body of a Polka webhook. ID is unique per event and
+// stays the same when Polka redelivers it. The period is only sent with
+// upgrades and renewals, and may be left out. OccurredAt says when the change
+// happened at Polka; without it the time the event was received is used.
+type polkaEvent struct {
+	ID         string     `json:"id"`
+	Event      Event      `json:"event"`
+	OccurredAt *time.Time `json:"occurred_at"`
+	Data       struct {
+		UserID             string     `json:"user_id"`
+		CurrentPeriodStart *time.Time `json:"current_period_start"`
+		CurrentPeriodEnd   *time.Time `json:"current_period_end"`
+	} `json:"data"`
+}
+
+// Processing an event fails with one of these when retrying it can't help.
+// The failure is recorded on the stored event.
+var (
+	errPolkaInvalidEvent = errors.New("invalid event data")
+	errPolkaUserNotFound = errors.New("user not found")
+)
 
-	providedKey, err := auth.GetAPIKey(r.Header)
+func polkaEventFailed(err error) bool {
+	return errors.Is(err, errPolkaInvalidEvent) || errors.Is(err, errPolkaUserNotFound)
+}
+
+// handlerPolkaWebhooks verifies the signature over the raw body, stores the
+// event and acts on it. A redelivered event that was already handled is
+// acknowledged without doing anything.
+func (cfg *apiConfig) handlerPolkaWebhooks(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "unable to extract api key", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read body", err)
 		return
 	}
 
-	if providedKey != cfg.polkaKey {
-		respondWithError(w, http.StatusUnauthorized, "invalid api key", nil)
+	err = polka.Verify(cfg.polkaWebhookSecret, r.Header.Get(polka.SignatureHeader), body, time.Now(), polka.DefaultTolerance)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid signature", err)
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	event := polkaEvent{}
+	err = json.Unmarshal(body, &event)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
-
-	if params.Event != string(EventUserUpgraded) {
-		w.WriteHeader(http.StatusNoContent)
+	if event.ID == "" || event.Event == "" {
+		respondWithError(w, http.StatusBadRequest, "Event ID and type are required", nil)
 		return
 	}
 
-	userID, err := uuid.Parse(params.Data.UserID)
+	err = cfg.db.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		ID:         event.ID,
+		Event:      string(event.Event),
+		Payload:    body,
+		ReceivedAt: time.Now().UTC(),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse uuid", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store event", err)
 		return
 	}
 
-	_, err = cfg.db.SetChirpyRed(r.Context(), userID)
+	_, err = cfg.processPolkaEvent(r.Context(), event.ID, false)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		switch {
+		case errors.Is(err, errPolkaUserNotFound):
 			respondWithError(w, http.StatusNotFound, "Couldn't find user with the provided id", err)
-			return
+		case errors.Is(err, errPolkaInvalidEvent):
+			respondWithError(w, http.StatusBadRequest, "Invalid event data", err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't process event", err)
 		}
-		respondWithError(w, http.StatusInternalServerError, "Couldn't set chirp to red", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// processPolkaEvent acts on a stored event and records the outcome. The row
+// stays locked meanwhile, so concurrent deliveries of one event take turns
+// and only the first one does anything. Events that were already handled are
+// only run again when replay is set.
+//
+// When the event fails for good, the failure is recorded and the updated
+// event is returned together with the error.
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, id string, replay bool) (database.WebhookEvent, error) {
+	tx, err := cfg.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	stored, err := qtx.LockWebhookEvent(ctx, id)
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	if !replay && (stored.Status == webhookStatusProcessed || stored.Status == webhookStatusIgnored) {
+		return stored, tx.Commit()
+	}
+
+	event := polkaEvent{}
+	applyErr := json.Unmarshal(stored.Payload, &event)
+	if applyErr != nil {
+		applyErr = fmt.Errorf("%w: %s", errPolkaInvalidEvent, applyErr)
+	}
+
+	status := webhookStatusFailed
+	if applyErr == nil {
+		status, applyErr = applyPolkaEvent(ctx, qtx, event, stored.ReceivedAt)
+	}
+	if applyErr != nil && !polkaEventFailed(applyErr) {
+		return stored, applyErr
+	}
+
+	lastError := sql.NullString{}
+	if applyErr != nil {
+		status = webhookStatusFailed
+		lastError = sql.NullString{String: applyErr.Error(), Valid: true}
+	}
+
+	stored, err = qtx.FinishWebhookEvent(ctx, database.FinishWebhookEventParams{
+		ID:          id,
+		Status:      status,
+		LastError:   lastError,
+		ProcessedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		return stored, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return stored, err
+	}
+	return stored, applyErr
+}
+
+// polkaStore is the part of database.Queries that applyPolkaEvent needs.
+type polkaStore interface {
+	GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error)
+	LockSubscription(ctx context.Context, userID uuid.UUID) (database.Subscription, error)
+	ActivateSubscription(ctx context.Context, arg database.ActivateSubscriptionParams) (database.Subscription, error)
+	EndSubscription(ctx context.Context, arg database.EndSubscriptionParams) error
+}
+
+// applyPolkaEvent makes the change event asks for and returns the status to
+// record. Event types Chirpy doesn't act on are ignored, and so are events
+// that arrived after a later one they would undo: downgrades and expiries
+// from before the current period started, and upgrades and renewals from
+// before the subscription was ended.
+func applyPolkaEvent(ctx context.Context, q polkaStore, event polkaEvent, receivedAt time.Time) (string, error) {
+	switch event.Event {
+	case EventUserUpgraded, EventUserDowngraded, EventSubscriptionRenewed, EventSubscriptionExpired:
+	default:
+		return webhookStatusIgnored, nil
+	}
+
+	userID, err := uuid.Parse(event.Data.UserID)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errPolkaInvalidEvent, err)
+	}
+
+	_, err = q.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errPolkaUserNotFound
+		}
+		return "", err
+	}
+
+	occurredAt := receivedAt.UTC()
+	if event.OccurredAt != nil {
+		occurredAt = event.OccurredAt.UTC()
+	}
+
+	sub, err := q.LockSubscription(ctx, userID)
+	hasSub := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	switch event.Event {
+	case EventUserUpgraded, EventSubscriptionRenewed:
+		start := occurredAt
+		if event.Data.CurrentPeriodStart != nil {
+			start = event.Data.CurrentPeriodStart.UTC()
+		}
+		end := start.Add(chirpyRedBillingPeriod)
+		if event.Data.CurrentPeriodEnd != nil {
+			end = event.Data.CurrentPeriodEnd.UTC()
+		}
+		if !end.After(start) {
+			return "", fmt.Errorf("%w: period ends before it starts", errPolkaInvalidEvent)
+		}
+
+		// Without occurred_at the period start is the best guess at when
+		// the upgrade or renewal happened.
+		activatedAt := occurredAt
+		if event.OccurredAt == nil && event.Data.CurrentPeriodStart != nil {
+			activatedAt = start
+		}
+		if hasSub && sub.EndedAt.Valid && activatedAt.Before(sub.EndedAt.Time) {
+			return webhookStatusIgnored, nil
+		}
+
+		_, err = q.ActivateSubscription(ctx, database.ActivateSubscriptionParams{
+			UserID:             userID,
+			Plan:               string(entitlements.PlanChirpyRed),
+			CurrentPeriodStart: start,
+			CurrentPeriodEnd:   end,
+		})
+	case EventUserDowngraded, EventSubscriptionExpired:
+		if !hasSub {
+			return webhookStatusProcessed, nil
+		}
+		if occurredAt.Before(sub.CurrentPeriodStart) {
+			return webhookStatusIgnored, nil
+		}
+
+		status := subscriptionStatusCanceled
+		if event.Event == EventSubscriptionExpired {
+			status = subscriptionStatusExpired
+		}
+		err = q.EndSubscription(ctx, database.EndSubscriptionParams{
+			Status:  status,
+			EndedAt: occurredAt,
+			UserID:  userID,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	return webhookStatusProcessed, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+// fakePolkaStore returns a fixed subscription and records the writes
+// applyPolkaEvent asks for. How the queries merge periods is up to SQL and
+// isn't modelled here.
+type fakePolkaStore struct {
+	userID uuid.UUID
+	sub    *database.Subscription
+
+	activated []database.ActivateSubscriptionParams
+	ended     []database.EndSubscriptionParams
+}
+
+func (s *fakePolkaStore) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	if id != s.userID {
+		return database.User{}, sql.ErrNoRows
+	}
+	return database.User{ID: id}, nil
+}
+
+func (s *fakePolkaStore) LockSubscription(ctx context.Context, userID uuid.UUID) (database.Subscription, error) {
+	if s.sub == nil || userID != s.userID {
+		return database.Subscription{}, sql.ErrNoRows
+	}
+	return *s.sub, nil
+}
+
+func (s *fakePolkaStore) ActivateSubscription(ctx context.Context, arg database.ActivateSubscriptionParams) (database.Subscription, error) {
+	s.activated = append(s.activated, arg)
+	return database.Subscription{}, nil
+}
+
+func (s *fakePolkaStore) EndSubscription(ctx context.Context, arg database.EndSubscriptionParams) error {
+	s.ended = append(s.ended, arg)
+	return nil
+}
+
+func TestApplyPolkaEventEndOrdering(t *testing.T) {
+	userID := uuid.New()
+	periodStart := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		event      Event
+		occurredAt time.Time
+		wantStatus string
+		wantEnded  string
+	}{
+		{"Expiry before the period started is ignored", EventSubscriptionExpired, periodStart.Add(-time.Minute), webhookStatusIgnored, ""},
+		{"Downgrade before the period started is ignored", EventUserDowngraded, periodStart.Add(-time.Hour), webhookStatusIgnored, ""},
+		{"Expiry in the current period ends it", EventSubscriptionExpired, periodStart.Add(time.Hour), webhookStatusProcessed, subscriptionStatusExpired},
+		{"Downgrade in the current period cancels it", EventUserDowngraded, periodStart, webhookStatusProcessed, subscriptionStatusCanceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakePolkaStore{
+				userID: userID,
+				sub: &database.Subscription{
+					UserID:             userID,
+					Status:             subscriptionStatusActive,
+					CurrentPeriodStart: periodStart,
+					CurrentPeriodEnd:   periodStart.Add(chirpyRedBillingPeriod),
+				},
+			}
+
+			ev := polkaEvent{ID: uuid.NewString(), Event: tt.event, OccurredAt: &tt.occurredAt}
+			ev.Data.UserID = userID.String()
+
+			status, err := applyPolkaEvent(context.Background(), store, ev, periodStart.Add(2*chirpyRedBillingPeriod))
+			if err != nil {
+				t.Fatalf("applyPolkaEvent() error = %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", status, tt.wantStatus)
+			}
+
+			if tt.wantEnded == "" {
+				if len(store.ended) != 0 {
+					t.Errorf("EndSubscription called with %+v, want no call", store.ended)
+				}
+				return
+			}
+			if len(store.ended) != 1 || store.ended[0].Status != tt.wantEnded || !store.ended[0].EndedAt.Equal(tt.occurredAt) {
+				t.Errorf("EndSubscription calls = %+v, want one with status %q ended at %v", store.ended, tt.wantEnded, tt.occurredAt)
+			}
+		})
+	}
+}
+
+func TestApplyPolkaEventActivationOrdering(t *testing.T) {
+	userID := uuid.New()
+	endedAt := time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC)
+	periodStart := endedAt.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name          string
+		event         Event
+		occurredAt    *time.Time
+		periodStart   *time.Time
+		wantStatus    string
+		wantActivated bool
+	}{
+		{"Renewal from before the cancellation is ignored", EventSubscriptionRenewed, ptr(endedAt.Add(-time.Minute)), nil, webhookStatusIgnored, false},
+		{"Upgrade from before the cancellation is ignored", EventUserUpgraded, ptr(endedAt.Add(-time.Hour)), nil, webhookStatusIgnored, false},
+		{"Period start is used without occurred_at", EventSubscriptionRenewed, nil, &periodStart, webhookStatusIgnored, false},
+		{"Upgrade after the cancellation reactivates", EventUserUpgraded, ptr(endedAt.Add(time.Hour)), nil, webhookStatusProcessed, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakePolkaStore{
+				userID: userID,
+				sub: &database.Subscription{
+					UserID:             userID,
+					Status:             subscriptionStatusCanceled,
+					CurrentPeriodStart: endedAt.Add(-chirpyRedBillingPeriod),
+					CurrentPeriodEnd:   endedAt.Add(chirpyRedBillingPeriod),
+					EndedAt:            sql.NullTime{Time: endedAt, Valid: true},
+				},
+			}
+
+			ev := polkaEvent{ID: uuid.NewString(), Event: tt.event, OccurredAt: tt.occurredAt}
+			ev.Data.UserID = userID.String()
+			ev.Data.CurrentPeriodStart = tt.periodStart
+
+			status, err := applyPolkaEvent(context.Background(), store, ev, endedAt.Add(2*time.Hour))
+			if err != nil {
+				t.Fatalf("applyPolkaEvent() error = %v", err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", status, tt.wantStatus)
+			}
+			if got := len(store.activated) == 1; got != tt.wantActivated {
+				t.Errorf("ActivateSubscription calls = %+v, want activated %v", store.activated, tt.wantActivated)
+			}
+		})
+	}
+}
+
+func TestApplyPolkaEventEndWithoutSubscription(t *testing.T) {
+	userID := uuid.New()
+	store := &fakePolkaStore{userID: userID}
+
+	ev := polkaEvent{ID: uuid.NewString(), Event: EventSubscriptionExpired}
+	ev.Data.UserID = userID.String()
+
+	status, err := applyPolkaEvent(context.Background(), store, ev, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("applyPolkaEvent() error = %v", err)
+	}
+	if status != webhookStatusProcessed {
+		t.Errorf("status = %q, want %q", status, webhookStatusProcessed)
+	}
+	if len(store.ended) != 0 {
+		t.Errorf("EndSubscription called with %+v, want no call", store.ended)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/entities"
+)
+
+const (
+	minHandleLength      = 3
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+// reservedHandles can't be claimed by users, either because they would read
+// as the service speaking or because they collide with API paths.
+var reservedHandles = map[string]bool{
+	"admin":    true,
+	"api":      true,
+	"chirpy":   true,
+	"help":     true,
+	"login":    true,
+	"logout":   true,
+	"me":       true,
+	"mentions": true,
+	"root":     true,
+	"settings": true,
+	"signup":   true,
+	"support":  true,
+	"system":   true,
+	"timeline": true,
+}
+
+func validateHandle(handle string) error {
+	if len(handle) < minHandleLength || len(handle) > entities.MaxHandleLength {
+		return fmt.Errorf("Handle must be between %d and %d characters", minHandleLength, entities.MaxHandleLength)
+	}
+	if !entities.ValidHandle(handle) {
+		return errors.New("Handle may only contain letters, digits and underscores")
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return errors.New("Handle is reserved")
+	}
+	return nil
+}
+
+func validateProfile(displayName, bio, avatarURL string) error {
+	err := validateDisplayName(displayName)
+	if err != nil {
+		return err
+	}
+	err = validateBio(bio)
+	if err != nil {
+		return err
+	}
+	return validateAvatarURL(avatarURL)
+}
+
+func validateDisplayName(displayName string) error {
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return fmt.Errorf("Display name must be at most %d characters", maxDisplayNameLength)
+	}
+	return nil
+}
+
+func validateBio(bio string) error {
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return fmt.Errorf("Bio must be at most %d characters", maxBioLength)
+	}
+	return nil
+}
+
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	if len(avatarURL) > maxAvatarURLLength {
+		return fmt.Errorf("Avatar URL must be at most %d characters", maxAvatarURLLength)
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Avatar URL must be an http or https URL")
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerGetProfile(w http.ResponseWriter, r *http.Request) {
+	handleOrID := r.PathValue("handleOrID")
+
+	var user database.User
+	id, err := uuid.Parse(handleOrID)
+	if err == nil {
+		user, err = cfg.db.GetUserByID(r.Context(), id)
+	} else {
+		user, err = cfg.db.GetUserByHandle(r.Context(), handleOrID)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	counts, err := cfg.db.GetUserProfileCounts(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch profile", err)
+		return
+	}
+
+	isChirpyRed, err := cfg.isChirpyRed(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch subscription", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Profile{
+		ID:             user.ID,
+		CreatedAt:      user.CreatedAt,
+		Handle:         user.Handle.String,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		AvatarURL:      user.AvatarUrl,
+		IsChirpyRed:    isChirpyRed,
+		ChirpCount:     counts.ChirpCount,
+		FollowerCount:  counts.FollowerCount,
+		FollowingCount: counts.FollowingCount,
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/search"
+)
+
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	tsQuery, err := search.ParseQuery(query.Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "q must contain at least one word", err)
+		return
+	}
+
+	authorID := uuid.NullUUID{}
+	if authorIDStr := query.Get("author_id"); authorIDStr != "" {
+		id, err := uuid.Parse(authorIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author_id", err)
+			return
+		}
+		authorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	since, err := parseTimeParam(query.Get("since"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp", err)
+		return
+	}
+
+	until, err := parseTimeParam(query.Get("until"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "until must be an RFC 3339 timestamp", err)
+		return
+	}
+
+	limit, cursor, err := parsePageParams(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	cursorRank := sql.NullFloat64{}
+	if cursor != nil {
+		if cursor.Rank == nil {
+			respondWithError(w, http.StatusBadRequest, "invalid cursor", nil)
+			return
+		}
+		cursorRank = sql.NullFloat64{Float64: float64(*cursor.Rank), Valid: true}
+	}
+	cursorCreatedAt, cursorID := cursorArgs(cursor)
+
+	result, err := cfg.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query:           tsQuery,
+		AuthorID:        authorID,
+		Since:           since,
+		Until:           until,
+		CursorRank:      cursorRank,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(limit + 1),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	hasMore := len(result) > limit
+	if hasMore {
+		result = result[:limit]
+	}
+
+	rows := make([]database.Chirp, 0, len(result))
+	for _, row := range result {
+		rows = append(rows, database.Chirp{
+			ID:           row.ID,
+			CreatedAt:    row.CreatedAt,
+			UpdatedAt:    row.UpdatedAt,
+			Body:         row.Body,
+			UserID:       row.UserID,
+			InReplyTo:    row.InReplyTo,
+			DeletedAt:    row.DeletedAt,
+			Kind:         row.Kind,
+			RechirpOf:    row.RechirpOf,
+			SearchVector: row.SearchVector,
+		})
+	}
+
+	chirps, err := cfg.chirpsFromDB(r.Context(), rows, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	if hasMore {
+		last := result[len(result)-1]
+		setNextPageLink(w, r, pageCursor{CreatedAt: last.CreatedAt, ID: last.ID, Rank: &last.Rank})
+	}
+
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter.
+func parseTimeParam(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+}
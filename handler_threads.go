@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+const (
+	maxThreadAncestors = 50
+	maxThreadDepth     = 10
+)
+
+func (cfg *apiConfig) handlerGetChirpThread(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse to uuid", err)
+		return
+	}
+
+	root, err := cfg.db.GetChirpByID(r.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find chirp with the provided id", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch chirp", err)
+		return
+	}
+
+	// Walk up to the top of the conversation, nearest parent first.
+	ancestors := []database.Chirp{}
+	parentID := root.InReplyTo
+	for parentID.Valid && len(ancestors) < maxThreadAncestors {
+		parent, err := cfg.db.GetChirpByID(r.Context(), parentID.UUID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch thread", err)
+			return
+		}
+		ancestors = append(ancestors, parent)
+		parentID = parent.InReplyTo
+	}
+
+	// Walk down one level of replies at a time.
+	replies := []database.Chirp{}
+	level := []uuid.UUID{root.ID}
+	for depth := 0; depth < maxThreadDepth && len(level) > 0; depth++ {
+		rows, err := cfg.db.ListRepliesByParentIDs(r.Context(), level)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't fetch thread", err)
+			return
+		}
+		level = level[:0]
+		for _, row := range rows {
+			replies = append(replies, row)
+			level = append(level, row.ID)
+		}
+	}
+
+	all := make([]database.Chirp, 0, 1+len(ancestors)+len(replies))
+	all = append(all, root)
+	all = append(all, ancestors...)
+	all = append(all, replies...)
+	chirps, err := cfg.chirpsFromDB(r.Context(), all, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch thread", err)
+		return
+	}
+
+	thread := ChirpThread{
+		Ancestors: make([]Chirp, 0, len(ancestors)),
+	}
+	for i := len(ancestors); i >= 1; i-- {
+		thread.Ancestors = append(thread.Ancestors, chirps[i])
+	}
+
+	children := make(map[uuid.UUID][]Chirp)
+	for _, reply := range chirps[1+len(ancestors):] {
+		children[*reply.InReplyTo] = append(children[*reply.InReplyTo], reply)
+	}
+	thread.Chirp = buildThreadNode(chirps[0], children)
+
+	respondWithJSON(w, http.StatusOK, thread)
+}
+
+func buildThreadNode(chirp Chirp, children map[uuid.UUID][]Chirp) ChirpThreadNode {
+	node := ChirpThreadNode{
+		Chirp:   chirp,
+		Replies: make([]ChirpThreadNode, 0, len(children[chirp.ID])),
+	}
+	for _, child := range children[chirp.ID] {
+		node.Replies = append(node.Replies, buildThreadNode(child, children))
+	}
+	return node
+}
@@ -1,12 +1,55 @@
 package main
 
 import (
+	"database/sql"
+	"log"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
 )
 
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+// createRefreshToken issues a new refresh token in familyID, recording the
+// device r came from. A login starts a new family and every rotation adds the
+// replacement token to the same one, so a family is one session.
+func createRefreshToken(r *http.Request, q *database.Queries, userID, familyID uuid.UUID) (string, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	_, err = q.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash:  auth.HashToken(token),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		UserID:     userID,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+		FamilyID:   familyID,
+		UserAgent:  r.UserAgent(),
+		IpAddress:  clientIP(r),
+		LastUsedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// clientIP returns the address of the peer that sent r, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -14,12 +57,18 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshToken, err := cfg.db.GetRefreshToken(r.Context(), token)
+	refreshToken, err := cfg.db.GetRefreshToken(r.Context(), auth.HashToken(token))
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "invalid refresh token", err)
 		return
 	}
 
+	if refreshToken.ReplacedBy.Valid {
+		cfg.revokeStolenFamily(r, refreshToken)
+		respondWithError(w, http.StatusUnauthorized, "refresh token already used", nil)
+		return
+	}
+
 	if refreshToken.ExpiresAt.UTC().Before(time.Now().UTC()) {
 		respondWithError(w, http.StatusUnauthorized, "refresh token already expired", nil)
 		return
@@ -31,22 +80,75 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
-	accessToken, err := auth.MakeJWT(refreshToken.UserID, cfg.jwtSecret, time.Hour)
+	accessToken, err := auth.MakeJWT(refreshToken.UserID, cfg.keyring, time.Hour)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create a new access token", err)
 		return
 	}
 
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate the refresh token", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	newToken, err := createRefreshToken(r, qtx, refreshToken.UserID, refreshToken.FamilyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate the refresh token", err)
+		return
+	}
+
+	rotated, err := qtx.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		RevokedAt:  time.Now().UTC(),
+		ReplacedBy: sql.NullString{String: auth.HashToken(newToken), Valid: true},
+		TokenHash:  refreshToken.TokenHash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate the refresh token", err)
+		return
+	}
+
+	// Another request rotated the same token between our read and update.
+	if rotated == 0 {
+		tx.Rollback()
+		cfg.revokeStolenFamily(r, refreshToken)
+		respondWithError(w, http.StatusUnauthorized, "refresh token already used", nil)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate the refresh token", err)
+		return
+	}
+
 	resp := response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newToken,
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 
 }
 
+// revokeStolenFamily is called when a refresh token that was already rotated
+// is presented again. Either the legitimate client or an attacker holds a
+// copy, and we can't tell which, so every token in the family is revoked and
+// both have to log in again.
+func (cfg *apiConfig) revokeStolenFamily(r *http.Request, refreshToken database.RefreshToken) {
+	log.Printf("Refresh token reuse detected: user %s, family %s, from %s", refreshToken.UserID, refreshToken.FamilyID, r.RemoteAddr)
+
+	err := cfg.db.RevokeRefreshTokenFamily(r.Context(), refreshToken.FamilyID)
+	if err != nil {
+		log.Printf("Couldn't revoke refresh token family %s: %s", refreshToken.FamilyID, err)
+	}
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -54,7 +156,7 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken)
+	err = cfg.db.RevokeRefreshToken(r.Context(), auth.HashToken(refreshToken))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke the refresh token", err)
 		return
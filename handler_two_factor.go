@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/totp"
+)
+
+const (
+	twoFactorIssuer   = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+// handlerEnrollTwoFactor starts 2FA setup. The secret stays pending, and
+// login keeps working without a code, until handlerVerifyTwoFactor sees a
+// code generated from it.
+func (cfg *apiConfig) handlerEnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+
+	sealed, err := cfg.totpSecrets.Seal(secret, userID[:])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	err = cfg.db.SetPendingTOTPSecret(r.Context(), database.SetPendingTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: sql.NullString{String: sealed, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(secret, twoFactorIssuer, user.Email),
+	})
+}
+
+// handlerVerifyTwoFactor finishes 2FA setup and hands out the recovery
+// codes. They are only ever shown here.
+func (cfg *apiConfig) handlerVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	if user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	if !user.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor enrollment hasn't been started", nil)
+		return
+	}
+
+	secret, err := cfg.totpSecrets.Open(user.TotpSecret.String, userID[:])
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read secret", err)
+		return
+	}
+
+	step, err := totp.Validate(secret, params.Code, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", err)
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.EnableTOTP(r.Context(), database.EnableTOTPParams{
+		ID:           userID,
+		TotpLastStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	err = replaceRecoveryCodes(r.Context(), qtx, userID, codes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+// handlerDisableTwoFactor turns 2FA off. It asks for a code as well as the
+// access token so that a stolen session can't quietly remove it.
+func (cfg *apiConfig) handlerDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	if !user.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication isn't enabled", nil)
+		return
+	}
+
+	err = cfg.checkSecondFactor(r.Context(), user, params.Code, params.RecoveryCode, time.Now())
+	if err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			respondWithError(w, http.StatusUnauthorized, "invalid two-factor code", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor code", err)
+		return
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.DisableTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	err = qtx.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginTwoFactor is the second login step for users with 2FA. It
+// takes the challenge token from handlerLogin and either a current code or
+// one of the recovery codes.
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := auth.ValidateChallengeJWT(params.ChallengeToken, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid challenge token", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid challenge token", err)
+		return
+	}
+
+	if !user.TotpEnabled {
+		respondWithError(w, http.StatusUnauthorized, "invalid challenge token", nil)
+		return
+	}
+
+	// Codes are short, so guesses count towards the same limits as
+	// passwords.
+	if !cfg.allowLoginAttempt(w, r, user.Email) {
+		return
+	}
+
+	err = cfg.checkSecondFactor(r.Context(), user, params.Code, params.RecoveryCode, time.Now())
+	if err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			cfg.recordLoginFailure(r, user.Email)
+			respondWithError(w, http.StatusUnauthorized, "invalid two-factor code", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor code", err)
+		return
+	}
+
+	cfg.loginAccountLimiter.Success(loginAccountKey(user.Email))
+	cfg.respondWithLogin(w, r, user)
+}
+
+// checkSecondFactor accepts either a TOTP code or a recovery code for user
+// and burns it so it can't be used again. It returns errInvalidSecondFactor
+// when neither is acceptable.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, user database.User, code, recoveryCode string, now time.Time) error {
+	if code != "" {
+		secret, err := cfg.totpSecrets.Open(user.TotpSecret.String, user.ID[:])
+		if err != nil {
+			return err
+		}
+
+		step, err := totp.Validate(secret, code, now)
+		if err != nil {
+			return errInvalidSecondFactor
+		}
+
+		used, err := cfg.db.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			ID:           user.ID,
+			TotpLastStep: step,
+		})
+		if err != nil {
+			return err
+		}
+		if used == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	if recoveryCode != "" {
+		used, err := cfg.db.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashToken(totp.NormalizeRecoveryCode(recoveryCode)),
+		})
+		if err != nil {
+			return err
+		}
+		if used == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	return errInvalidSecondFactor
+}
+
+func replaceRecoveryCodes(ctx context.Context, q *database.Queries, userID uuid.UUID, codes []string) error {
+	err := q.DeleteRecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, code := range codes {
+		err = q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			ID:        uuid.New(),
+			UserID:    userID,
+			CodeHash:  auth.HashToken(totp.NormalizeRecoveryCode(code)),
+			CreatedAt: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealPlaintextTOTPSecrets encrypts secrets that were stored before
+// cfg.totpSecrets existed. It runs before the server starts, so every secret
+// it reads afterwards is sealed.
+func (cfg *apiConfig) sealPlaintextTOTPSecrets(ctx context.Context) error {
+	rows, err := cfg.db.ListPlaintextTOTPSecrets(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		sealed, err := cfg.totpSecrets.Seal(row.TotpSecret, row.ID[:])
+		if err != nil {
+			return err
+		}
+		err = cfg.db.SealTOTPSecret(ctx, database.SealTOTPSecretParams{
+			SealedSecret: sealed,
+			ID:           row.ID,
+			PlainSecret:  row.TotpSecret,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(rows) > 0 {
+		log.Printf("Encrypted %d two-factor secrets", len(rows))
+	}
+	return nil
+}
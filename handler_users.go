@@ -1,19 +1,27 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/trungdoanle1101/chirp/internal/auth"
 	"github.com/trungdoanle1101/chirp/internal/database"
 )
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
 	type parameter struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+		AvatarURL   string `json:"avatar_url"`
 	}
 
 	params := parameter{}
@@ -24,8 +32,38 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", nil)
+		return
+	}
+
+	handle := sql.NullString{}
+	if params.Handle != "" {
+		err = validateHandle(params.Handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		handle = sql.NullString{String: params.Handle, Valid: true}
+	}
+
+	err = validateProfile(params.DisplayName, params.Bio, params.AvatarURL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	err = auth.CheckPasswordStrength(params.Password, params.Email, params.Handle)
+	if err != nil {
+		respondWithValidationErrors(w, map[string]string{"password": "Password " + err.Error()})
+		return
+	}
+
+	hashedPassword, err := cfg.passwords.Hash(params.Password)
 	if err != nil {
+		if respondIfHasherBusy(w, err) {
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
 	}
@@ -36,28 +74,40 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		UpdatedAt:      time.Now().UTC(),
 		Email:          params.Email,
 		HashedPassword: hashedPassword,
+		Handle:         handle,
+		DisplayName:    params.DisplayName,
+		Bio:            params.Bio,
+		AvatarUrl:      params.AvatarURL,
 	}
 
 	result, err := cfg.db.CreateUser(r.Context(), cuparams)
 	if err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Email or handle already taken", err)
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
 
-	user := User{
-		ID:        result.ID,
-		CreatedAt: result.CreatedAt,
-		UpdatedAt: result.UpdatedAt,
-		Email:     result.Email,
+	err = cfg.sendVerificationEmail(r.Context(), result.ID, result.Email)
+	if err != nil {
+		log.Printf("Couldn't send verification email to user %s: %s", result.ID, err)
 	}
 
-	respondWithJSON(w, http.StatusCreated, user)
+	// A new account can't have a subscription yet.
+	respondWithJSON(w, http.StatusCreated, userFromDB(result, false))
 }
 
 func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           string  `json:"email"`
+		Password        string  `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		Handle          string  `json:"handle"`
+		DisplayName     *string `json:"display_name"`
+		Bio             *string `json:"bio"`
+		AvatarURL       *string `json:"avatar_url"`
 	}
 
 	type response struct {
@@ -70,7 +120,7 @@ func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	id, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	id, err := auth.ValidateJWT(token, cfg.keyring)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
 		return
@@ -84,32 +134,214 @@ func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	result, err := cfg.db.GetUserByID(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	fields := map[string]string{}
+	if !validEmail(params.Email) {
+		fields["email"] = "Invalid email address"
+	}
+	handle := result.Handle.String
+	if params.Handle != "" {
+		handle = params.Handle
+	}
+	err = auth.CheckPasswordStrength(params.Password, result.Email, params.Email, handle)
+	if err != nil {
+		fields["password"] = "Password " + err.Error()
+	}
+	if params.Handle != "" {
+		err = validateHandle(params.Handle)
+		if err != nil {
+			fields["handle"] = err.Error()
+		}
+	}
+	if params.DisplayName != nil {
+		err = validateDisplayName(*params.DisplayName)
+		if err != nil {
+			fields["display_name"] = err.Error()
+		}
+	}
+	if params.Bio != nil {
+		err = validateBio(*params.Bio)
+		if err != nil {
+			fields["bio"] = err.Error()
+		}
+	}
+	if params.AvatarURL != nil {
+		err = validateAvatarURL(*params.AvatarURL)
+		if err != nil {
+			fields["avatar_url"] = err.Error()
+		}
+	}
+	// PUT always sets the password, so it needs the current one just like
+	// changing the password or email through PATCH /api/users/me.
+	if params.CurrentPassword == "" {
+		fields["current_password"] = "Current password is required to change the email or password"
+	}
+	if len(fields) > 0 {
+		respondWithValidationErrors(w, fields)
+		return
+	}
+
+	// The current password can be guessed here as well as at login.
+	if !cfg.allowLoginAttempt(w, r, result.Email) {
+		return
+	}
+	err = cfg.passwords.Check(params.CurrentPassword, result.HashedPassword)
+	if err != nil {
+		if respondIfHasherBusy(w, err) {
+			return
+		}
+		cfg.recordLoginFailure(r, result.Email)
+		respondWithError(w, http.StatusForbidden, "Current password is incorrect", err)
+		return
+	}
+
+	emailChanged := params.Email != result.Email && params.Email != result.PendingEmail.String
+	if emailChanged {
+		_, err = cfg.db.GetUserByEmail(r.Context(), params.Email)
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "Email already taken", nil)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+	}
+
+	hashedPassword, err := cfg.passwords.Hash(params.Password)
 	if err != nil {
+		if respondIfHasherBusy(w, err) {
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
 	}
 
-	updateUserParams := database.UpdateUserParams{
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
 		ID:             id,
-		Email:          params.Email,
 		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	// A new email only replaces the current one once the user follows the
+	// link sent to it.
+	if emailChanged {
+		result, err = qtx.SetPendingEmail(r.Context(), database.SetPendingEmailParams{
+			ID:           id,
+			PendingEmail: sql.NullString{String: params.Email, Valid: true},
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+	}
+
+	if params.Handle != "" {
+		result, err = qtx.SetUserHandle(r.Context(), database.SetUserHandleParams{
+			ID:     id,
+			Handle: sql.NullString{String: params.Handle, Valid: true},
+		})
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondWithValidationErrors(w, map[string]string{"handle": "Handle already taken"})
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update handle", err)
+			return
+		}
+	}
+
+	// Profile fields left out of the request keep their current value.
+	if params.DisplayName != nil || params.Bio != nil || params.AvatarURL != nil {
+		profile := database.UpdateUserProfileParams{
+			ID:          id,
+			DisplayName: result.DisplayName,
+			Bio:         result.Bio,
+			AvatarUrl:   result.AvatarUrl,
+		}
+		if params.DisplayName != nil {
+			profile.DisplayName = *params.DisplayName
+		}
+		if params.Bio != nil {
+			profile.Bio = *params.Bio
+		}
+		if params.AvatarURL != nil {
+			profile.AvatarUrl = *params.AvatarURL
+		}
+
+		result, err = qtx.UpdateUserProfile(r.Context(), profile)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
 	}
 
-	result, err := cfg.db.UpdateUser(r.Context(), updateUserParams)
+	if emailChanged {
+		err = cfg.sendVerificationEmail(r.Context(), id, params.Email)
+		if err != nil {
+			log.Printf("Couldn't send verification email to user %s: %s", id, err)
+		}
+	}
+
+	isChirpyRed, err := cfg.isChirpyRed(r.Context(), result.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update email and password", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch subscription", err)
 		return
 	}
 
 	resp := response{
-		User: User{
-			ID:        result.ID,
-			CreatedAt: result.CreatedAt,
-			UpdatedAt: result.UpdatedAt,
-			Email:     result.Email,
-		},
+		User: userFromDB(result, isChirpyRed),
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 
 }
+
+func userFromDB(user database.User, isChirpyRed bool) User {
+	u := User{
+		ID:               user.ID,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+		Email:            user.Email,
+		Handle:           user.Handle.String,
+		DisplayName:      user.DisplayName,
+		Bio:              user.Bio,
+		AvatarURL:        user.AvatarUrl,
+		IsChirpyRed:      isChirpyRed,
+		TwoFactorEnabled: user.TotpEnabled,
+		EmailVerified:    user.EmailVerifiedAt.Valid,
+		PendingEmail:     user.PendingEmail.String,
+	}
+	if user.DeleteAfter.Valid {
+		deleteAfter := user.DeleteAfter.Time
+		u.DeleteAfter = &deleteAfter
+	}
+	return u
+}
+
+// isUniqueViolation reports whether err was caused by a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
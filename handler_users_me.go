@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/trungdoanle1101/chirp/internal/auth"
+	"github.com/trungdoanle1101/chirp/internal/database"
+)
+
+// handlerPatchUser updates only the fields present in the request. Changing
+// the email or password also needs the current password, and an access token
+// from a login rather than a personal access token.
+func (cfg *apiConfig) handlerPatchUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		Handle          *string `json:"handle"`
+		DisplayName     *string `json:"display_name"`
+		Bio             *string `json:"bio"`
+		AvatarURL       *string `json:"avatar_url"`
+	}
+
+	id, err := cfg.authenticate(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(r.Context(), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch user", err)
+		return
+	}
+
+	if params.Email != nil && (*params.Email == user.Email || *params.Email == user.PendingEmail.String) {
+		params.Email = nil
+	}
+	if params.Handle != nil && *params.Handle == user.Handle.String {
+		params.Handle = nil
+	}
+
+	fields := map[string]string{}
+	if params.Email != nil && !validEmail(*params.Email) {
+		fields["email"] = "Invalid email address"
+	}
+	if params.Password != nil {
+		handle := user.Handle.String
+		if params.Handle != nil {
+			handle = *params.Handle
+		}
+		err = auth.CheckPasswordStrength(*params.Password, user.Email, handle)
+		if err != nil {
+			fields["password"] = "Password " + err.Error()
+		}
+	}
+	if params.Handle != nil {
+		err = validateHandle(*params.Handle)
+		if err != nil {
+			fields["handle"] = err.Error()
+		}
+	}
+	if params.DisplayName != nil {
+		err = validateDisplayName(*params.DisplayName)
+		if err != nil {
+			fields["display_name"] = err.Error()
+		}
+	}
+	if params.Bio != nil {
+		err = validateBio(*params.Bio)
+		if err != nil {
+			fields["bio"] = err.Error()
+		}
+	}
+	if params.AvatarURL != nil {
+		err = validateAvatarURL(*params.AvatarURL)
+		if err != nil {
+			fields["avatar_url"] = err.Error()
+		}
+	}
+	if (params.Email != nil || params.Password != nil) && params.CurrentPassword == "" {
+		fields["current_password"] = "Current password is required to change the email or password"
+	}
+	if len(fields) > 0 {
+		respondWithValidationErrors(w, fields)
+		return
+	}
+
+	if params.Email != nil || params.Password != nil {
+		token, err := auth.GetBearerToken(r.Header)
+		if err == nil && auth.IsPersonalAccessToken(token) {
+			respondWithError(w, http.StatusForbidden, "Personal access tokens can't change the email or password", nil)
+			return
+		}
+
+		// The current password can be guessed here as well as at login.
+		if !cfg.allowLoginAttempt(w, r, user.Email) {
+			return
+		}
+		err = cfg.passwords.Check(params.CurrentPassword, user.HashedPassword)
+		if err != nil {
+			if respondIfHasherBusy(w, err) {
+				return
+			}
+			cfg.recordLoginFailure(r, user.Email)
+			respondWithError(w, http.StatusForbidden, "Current password is incorrect", err)
+			return
+		}
+	}
+
+	if params.Email != nil {
+		_, err = cfg.db.GetUserByEmail(r.Context(), *params.Email)
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "Email already taken", nil)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+	}
+
+	tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if params.Password != nil {
+		hashedPassword, err := cfg.passwords.Hash(*params.Password)
+		if err != nil {
+			if respondIfHasherBusy(w, err) {
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+
+		err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             id,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+			return
+		}
+	}
+
+	// A new email only replaces the current one once the user follows the
+	// link sent to it.
+	if params.Email != nil {
+		user, err = qtx.SetPendingEmail(r.Context(), database.SetPendingEmailParams{
+			ID:           id,
+			PendingEmail: sql.NullString{String: *params.Email, Valid: true},
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+	}
+
+	if params.Handle != nil {
+		user, err = qtx.SetUserHandle(r.Context(), database.SetUserHandleParams{
+			ID:     id,
+			Handle: sql.NullString{String: *params.Handle, Valid: true},
+		})
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondWithValidationErrors(w, map[string]string{"handle": "Handle already taken"})
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update handle", err)
+			return
+		}
+	}
+
+	if params.DisplayName != nil || params.Bio != nil || params.AvatarURL != nil {
+		profile := database.UpdateUserProfileParams{
+			ID:          id,
+			DisplayName: user.DisplayName,
+			Bio:         user.Bio,
+			AvatarUrl:   user.AvatarUrl,
+		}
+		if params.DisplayName != nil {
+			profile.DisplayName = *params.DisplayName
+		}
+		if params.Bio != nil {
+			profile.Bio = *params.Bio
+		}
+		if params.AvatarURL != nil {
+			profile.AvatarUrl = *params.AvatarURL
+		}
+
+		user, err = qtx.UpdateUserProfile(r.Context(), profile)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	if params.Email != nil {
+		err = cfg.sendVerificationEmail(r.Context(), id, *params.Email)
+		if err != nil {
+			log.Printf("Couldn't send verification email to user %s: %s", id, err)
+		}
+	}
+
+	isChirpyRed, err := cfg.isChirpyRed(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch subscription", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user, isChirpyRed))
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,32 +12,59 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type TokenType string
 
-const TokenTypeAccess TokenType = "chirpy-access"
+const (
+	TokenTypeAccess TokenType = "chirpy-access"
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	// TokenTypeTwoFactorChallenge proves the password was checked and the
+	// user still has to enter a one-time code. It isn't an access token.
+	TokenTypeTwoFactorChallenge TokenType = "chirpy-2fa-challenge"
+)
+
+// MakeJWT signs an access token for userID with the keyring's signing key.
+func MakeJWT(userID uuid.UUID, keyring *Keyring, expiresIn time.Duration) (string, error) {
+	return makeToken(userID, keyring, expiresIn, TokenTypeAccess)
+}
+
+// ValidateJWT checks an access token against the key named by its kid header
+// and returns the user it was issued to.
+func ValidateJWT(tokenString string, keyring *Keyring) (uuid.UUID, error) {
+	return validateToken(tokenString, keyring, TokenTypeAccess)
+}
+
+// MakeChallengeJWT signs a two-factor challenge token for userID.
+func MakeChallengeJWT(userID uuid.UUID, keyring *Keyring, expiresIn time.Duration) (string, error) {
+	return makeToken(userID, keyring, expiresIn, TokenTypeTwoFactorChallenge)
+}
+
+// ValidateChallengeJWT checks a two-factor challenge token and returns the
+// user who passed the first login step.
+func ValidateChallengeJWT(tokenString string, keyring *Keyring) (uuid.UUID, error) {
+	return validateToken(tokenString, keyring, TokenTypeTwoFactorChallenge)
+}
+
+func makeToken(userID uuid.UUID, keyring *Keyring, expiresIn time.Duration, tokenType TokenType) (string, error) {
 	now := time.Now().UTC()
 	expires := now.Add(expiresIn)
 	claims := jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
+		Issuer:    string(tokenType),
 		Subject:   userID.String(),
 		IssuedAt:  jwt.NewNumericDate(now),
 		ExpiresAt: jwt.NewNumericDate(expires),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(tokenSecret))
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = keyring.signingKID
+	return token.SignedString(keyring.signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+func validateToken(tokenString string, keyring *Keyring, tokenType TokenType) (uuid.UUID, error) {
 	claims := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
-		return []byte(tokenSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &claims, keyring.verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -50,7 +78,7 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(tokenType) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
@@ -62,6 +90,25 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return id, nil
 }
 
+func (k *Keyring) verificationKey(token *jwt.Token) (any, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		if k.legacySecret == nil {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return k.legacySecret, nil
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("missing key ID")
+	}
+	key, ok := k.publicKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
 func MakeRefreshToken() (string, error) {
 	n := 32
 	b := make([]byte, n)
@@ -74,6 +121,14 @@ func MakeRefreshToken() (string, error) {
 
 }
 
+// HashToken returns the digest stored in place of a refresh or personal
+// access token, so that a copy of the database can't be used to act as
+// anyone.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -101,15 +156,3 @@ func GetAPIKey(headers http.Header) (string, error) {
 	key := strings.TrimPrefix(authHeader, "ApiKey ")
 	return key, nil
 }
-
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
-}
-
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-}
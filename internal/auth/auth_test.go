@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"crypto/ed25519"
 	"net/http"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -65,33 +67,77 @@ func TestCheckPasswordHash(t *testing.T) {
 
 func TestValidateJWT(t *testing.T) {
 	userID := uuid.New()
-	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	keyring := newTestKeyring(t, "current", nil)
+	otherKeyring := newTestKeyring(t, "current", nil)
+	validToken, _ := MakeJWT(userID, keyring, time.Hour)
+	expiredToken, _ := MakeJWT(userID, keyring, -time.Hour)
+
+	// After a rotation the old key only verifies.
+	rotated := newTestKeyring(t, "next", map[string]ed25519.PublicKey{
+		"current": keyring.publicKeys["current"],
+	})
+
+	legacyToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Subject:   userID.String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte("secret"))
+	legacyKeyring := newTestKeyring(t, "current", nil)
+	legacyKeyring.AcceptLegacySecret("secret")
 
 	tests := []struct {
 		name        string
 		tokenString string
-		tokenSecret string
+		keyring     *Keyring
 		wantUserID  uuid.UUID
 		wantErr     bool
 	}{
 		{
 			name:        "Valid token",
 			tokenString: validToken,
-			tokenSecret: "secret",
+			keyring:     keyring,
 			wantUserID:  userID,
 			wantErr:     false,
 		},
 		{
 			name:        "Invalid token",
 			tokenString: "invalid.token.string",
-			tokenSecret: "secret",
+			keyring:     keyring,
+			wantUserID:  uuid.Nil,
+			wantErr:     true,
+		},
+		{
+			name:        "Wrong key",
+			tokenString: validToken,
+			keyring:     otherKeyring,
+			wantUserID:  uuid.Nil,
+			wantErr:     true,
+		},
+		{
+			name:        "Expired token",
+			tokenString: expiredToken,
+			keyring:     keyring,
 			wantUserID:  uuid.Nil,
 			wantErr:     true,
 		},
 		{
-			name:        "Wrong secret",
+			name:        "Retiring key still verifies",
 			tokenString: validToken,
-			tokenSecret: "wrong_secret",
+			keyring:     rotated,
+			wantUserID:  userID,
+			wantErr:     false,
+		},
+		{
+			name:        "Legacy HS256 token accepted",
+			tokenString: legacyToken,
+			keyring:     legacyKeyring,
+			wantUserID:  userID,
+			wantErr:     false,
+		},
+		{
+			name:        "Legacy HS256 token rejected without secret",
+			tokenString: legacyToken,
+			keyring:     keyring,
 			wantUserID:  uuid.Nil,
 			wantErr:     true,
 		},
@@ -99,7 +145,7 @@ func TestValidateJWT(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotUserID, err := ValidateJWT(tt.tokenString, tt.tokenSecret)
+			gotUserID, err := ValidateJWT(tt.tokenString, tt.keyring)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -125,3 +171,55 @@ func TestGetBearerToken(t *testing.T) {
 		t.Errorf("mismatch between expected and actual tokens\nExpected: %s\nActual: %s\n", expected, token)
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("failed to make refresh token: %v", err)
+	}
+
+	hash := HashToken(token)
+	if hash == token {
+		t.Errorf("hash must not equal the token")
+	}
+	if len(hash) != 64 {
+		t.Errorf("expected a 64 character hex digest, got %d characters", len(hash))
+	}
+	if HashToken(token) != hash {
+		t.Errorf("hashing the same token twice gave different digests")
+	}
+
+	other, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("failed to make refresh token: %v", err)
+	}
+	if HashToken(other) == hash {
+		t.Errorf("different tokens gave the same digest")
+	}
+}
+
+func TestChallengeJWT(t *testing.T) {
+	userID := uuid.New()
+	keyring := newTestKeyring(t, "current", nil)
+
+	challenge, err := MakeChallengeJWT(userID, keyring, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("MakeChallengeJWT() error = %v", err)
+	}
+	gotUserID, err := ValidateChallengeJWT(challenge, keyring)
+	if err != nil {
+		t.Fatalf("ValidateChallengeJWT() error = %v", err)
+	}
+	if gotUserID != userID {
+		t.Errorf("ValidateChallengeJWT() gotUserID = %v, want %v", gotUserID, userID)
+	}
+
+	if _, err := ValidateJWT(challenge, keyring); err == nil {
+		t.Errorf("ValidateJWT() accepted a challenge token as an access token")
+	}
+
+	access, _ := MakeJWT(userID, keyring, time.Hour)
+	if _, err := ValidateChallengeJWT(access, keyring); err == nil {
+		t.Errorf("ValidateChallengeJWT() accepted an access token")
+	}
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Keyring holds the Ed25519 keys used for access tokens. One private key
+// signs new tokens; every key in the ring, including public-only keys that
+// are being retired, verifies them. Each key is identified by a kid that is
+// written into the token header.
+type Keyring struct {
+	signingKID string
+	signingKey ed25519.PrivateKey
+	publicKeys map[string]ed25519.PublicKey
+
+	// legacySecret verifies HS256 tokens issued before the switch to
+	// asymmetric keys. It is never used to sign.
+	legacySecret []byte
+}
+
+// NewKeyring returns a keyring that signs with signingKey under signingKID
+// and also verifies tokens signed by any of verifyOnly.
+func NewKeyring(signingKID string, signingKey ed25519.PrivateKey, verifyOnly map[string]ed25519.PublicKey) (*Keyring, error) {
+	if signingKID == "" {
+		return nil, errors.New("signing key ID must not be empty")
+	}
+	if len(signingKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid Ed25519 signing key")
+	}
+
+	publicKeys := make(map[string]ed25519.PublicKey, len(verifyOnly)+1)
+	for kid, key := range verifyOnly {
+		publicKeys[kid] = key
+	}
+	publicKeys[signingKID] = signingKey.Public().(ed25519.PublicKey)
+
+	return &Keyring{
+		signingKID: signingKID,
+		signingKey: signingKey,
+		publicKeys: publicKeys,
+	}, nil
+}
+
+// LoadKeyring reads every *.pem file in dir. The file name without the
+// extension is the kid. A file may hold a PKCS#8 Ed25519 private key or, for
+// a key that is being retired, just its PKIX public key. signingKID picks the
+// private key that signs new tokens; it may be empty when dir holds exactly
+// one private key.
+//
+// To rotate, make sure signingKID names the current key, since it can't be
+// inferred once dir holds two private keys. Then add the new private key and
+// deploy, so every instance accepts tokens it signs, point signingKID at it
+// and deploy again, and delete the old file once tokens it signed have
+// expired.
+func LoadKeyring(dir, signingKID string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	privateKeys := map[string]ed25519.PrivateKey{}
+	publicKeys := map[string]ed25519.PublicKey{}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		private, public, err := parseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		if private != nil {
+			privateKeys[kid] = private
+		} else {
+			publicKeys[kid] = public
+		}
+	}
+
+	if signingKID == "" {
+		if len(privateKeys) != 1 {
+			return nil, fmt.Errorf("found %d private keys in %s, the signing key ID must be set", len(privateKeys), dir)
+		}
+		for kid := range privateKeys {
+			signingKID = kid
+		}
+	}
+
+	signingKey, ok := privateKeys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("no private key with ID %q in %s", signingKID, dir)
+	}
+
+	for kid, key := range privateKeys {
+		publicKeys[kid] = key.Public().(ed25519.PublicKey)
+	}
+
+	return NewKeyring(signingKID, signingKey, publicKeys)
+}
+
+func parseKey(data []byte) (ed25519.PrivateKey, ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		private, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, nil, errors.New("not an Ed25519 private key")
+		}
+		return private, nil, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, nil, errors.New("not an Ed25519 public key")
+		}
+		return nil, public, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// AcceptLegacySecret lets the keyring verify HS256 tokens signed with secret,
+// so access tokens issued before the move to Ed25519 keep working until they
+// expire.
+func (k *Keyring) AcceptLegacySecret(secret string) {
+	k.legacySecret = []byte(secret)
+}
+
+// JWK is a public key in JSON Web Key format (RFC 8037 for Ed25519).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key in the ring, sorted by kid.
+func (k *Keyring) JWKS() JWKS {
+	kids := make([]string, 0, len(k.publicKeys))
+	for kid := range k.publicKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		jwks.Keys = append(jwks.Keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(k.publicKeys[kid]),
+			KeyID:     kid,
+			Algorithm: "EdDSA",
+			Use:       "sig",
+		})
+	}
+	return jwks
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestKeyring(t *testing.T, kid string, verifyOnly map[string]ed25519.PublicKey) *Keyring {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyring, err := NewKeyring(kid, private, verifyOnly)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	return keyring
+}
+
+func writeKey(t *testing.T, dir, kid string, private bool) ed25519.PublicKey {
+	t.Helper()
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	block := &pem.Block{}
+	if private {
+		block.Type = "PRIVATE KEY"
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+	} else {
+		block.Type = "PUBLIC KEY"
+		block.Bytes, err = x509.MarshalPKIXPublicKey(public)
+	}
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return public
+}
+
+func TestLoadKeyring(t *testing.T) {
+	tests := []struct {
+		name       string
+		private    []string
+		public     []string
+		signingKID string
+		wantKID    string
+		wantErr    bool
+	}{
+		{
+			name:    "Single private key signs",
+			private: []string{"2024-01"},
+			wantKID: "2024-01",
+		},
+		{
+			name:    "Public keys only verify",
+			private: []string{"2024-02"},
+			public:  []string{"2024-01"},
+			wantKID: "2024-02",
+		},
+		{
+			name:       "Signing key picked from several",
+			private:    []string{"2024-01", "2024-02"},
+			signingKID: "2024-02",
+			wantKID:    "2024-02",
+		},
+		{
+			name:    "Several private keys need a signing key ID",
+			private: []string{"2024-01", "2024-02"},
+			wantErr: true,
+		},
+		{
+			name:       "Signing key ID must name a private key",
+			private:    []string{"2024-02"},
+			public:     []string{"2024-01"},
+			signingKID: "2024-01",
+			wantErr:    true,
+		},
+		{
+			name:    "Empty directory",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, kid := range tt.private {
+				writeKey(t, dir, kid, true)
+			}
+			for _, kid := range tt.public {
+				writeKey(t, dir, kid, false)
+			}
+
+			keyring, err := LoadKeyring(dir, tt.signingKID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if keyring.signingKID != tt.wantKID {
+				t.Errorf("LoadKeyring() signing kid = %q, want %q", keyring.signingKID, tt.wantKID)
+			}
+			if len(keyring.publicKeys) != len(tt.private)+len(tt.public) {
+				t.Errorf("LoadKeyring() loaded %d keys, want %d", len(keyring.publicKeys), len(tt.private)+len(tt.public))
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old", true)
+	before, err := LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+
+	userID := uuid.New()
+	token, err := MakeJWT(userID, before, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+
+	writeKey(t, dir, "new", true)
+	after, err := LoadKeyring(dir, "new")
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+
+	gotUserID, err := ValidateJWT(token, after)
+	if err != nil {
+		t.Fatalf("token signed before rotation rejected: %v", err)
+	}
+	if gotUserID != userID {
+		t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, userID)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "b", true)
+	public := writeKey(t, dir, "a", false)
+
+	keyring, err := LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+
+	jwks := keyring.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() returned %d keys, want 2", len(jwks.Keys))
+	}
+
+	key := jwks.Keys[0]
+	if key.KeyID != "a" || key.KeyType != "OKP" || key.Curve != "Ed25519" || key.Algorithm != "EdDSA" {
+		t.Errorf("JWKS() first key = %+v", key)
+	}
+	if key.X != base64.RawURLEncoding.EncodeToString(public) {
+		t.Errorf("JWKS() x = %q, want the encoded public key", key.X)
+	}
+	if jwks.Keys[1].KeyID != "b" {
+		t.Errorf("JWKS() second kid = %q, want %q", jwks.Keys[1].KeyID, "b")
+	}
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2idParams are the cost settings for new password hashes. They are
+// stored in every hash, so changing them only affects hashes made afterwards;
+// older ones are upgraded the next time their owner logs in.
+type Argon2idParams struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation for argon2id.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var errPasswordMismatch = errors.New("password does not match")
+
+// ErrHasherBusy is returned when every argon2id slot stayed taken for longer
+// than a caller should wait. Callers should ask the client to retry later.
+var ErrHasherBusy = errors.New("too many passwords being hashed")
+
+// hashSlotWait is how long a hash or check waits for a free slot.
+const hashSlotWait = 5 * time.Second
+
+// PasswordHasher hashes new passwords with argon2id and checks passwords
+// against either argon2id hashes or the bcrypt hashes made before it. Hashes
+// use the PHC string format, so the algorithm, version and parameters travel
+// with each one.
+type PasswordHasher struct {
+	params Argon2idParams
+	// dummyHash costs as much to check as a real hash.
+	dummyHash string
+
+	// slots bounds how many argon2id computations run at once. Each one
+	// allocates its Memory parameter, so without a bound a burst of logins
+	// could exhaust memory. Nil means no bound.
+	slots    chan struct{}
+	slotWait time.Duration
+}
+
+func NewPasswordHasher(params Argon2idParams) (*PasswordHasher, error) {
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 || params.SaltLength == 0 || params.KeyLength == 0 {
+		return nil, errors.New("argon2id parameters must all be positive")
+	}
+
+	h := &PasswordHasher{
+		params:   params,
+		slots:    make(chan struct{}, runtime.GOMAXPROCS(0)),
+		slotWait: hashSlotWait,
+	}
+	dummyHash, err := h.Hash("not a real password")
+	if err != nil {
+		return nil, err
+	}
+	h.dummyHash = dummyHash
+	return h, nil
+}
+
+// Hash returns an argon2id hash of password. Unlike bcrypt, every byte of
+// the password counts.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := h.idKey(password, salt, h.params)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Check returns nil if password matches hash.
+func (h *PasswordHasher) Check(password, hash string) error {
+	if isBcryptHash(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other, err := h.idKey(password, salt, params)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
+// CheckDummy does the same work as Check but always fails. Call it when
+// there is no account to check against so the response takes as long as it
+// would for a wrong password. Like Check, it returns ErrHasherBusy when it
+// couldn't run, so callers can treat both cases alike.
+func (h *PasswordHasher) CheckDummy(password string) error {
+	err := h.Check(password, h.dummyHash)
+	if errors.Is(err, ErrHasherBusy) {
+		return err
+	}
+	return errPasswordMismatch
+}
+
+// idKey runs argon2id once a slot is free.
+func (h *PasswordHasher) idKey(password string, salt []byte, params Argon2idParams) ([]byte, error) {
+	if h.slots != nil {
+		timer := time.NewTimer(h.slotWait)
+		defer timer.Stop()
+		select {
+		case h.slots <- struct{}{}:
+		case <-timer.C:
+			return nil, ErrHasherBusy
+		}
+		defer func() { <-h.slots }()
+	}
+	return argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength), nil
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or other
+// parameters than h uses now.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	params, salt, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	params.SaltLength = uint32(len(salt))
+	return params != h.params
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Argon2idParams{}, nil, nil, errors.New("unsupported password hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2idParams{}, nil, nil, errors.New("unsupported argon2id version")
+	}
+
+	params := Argon2idParams{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2idParams{}, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+var defaultPasswordHasher = &PasswordHasher{params: DefaultArgon2idParams}
+
+// HashPassword hashes password with DefaultArgon2idParams.
+func HashPassword(password string) (string, error) {
+	return defaultPasswordHasher.Hash(password)
+}
+
+// CheckPasswordHash returns nil if password matches an argon2id or bcrypt
+// hash.
+func CheckPasswordHash(password, hash string) error {
+	return defaultPasswordHasher.Check(password, hash)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinPasswordLength = 10
+	// MaxPasswordBytes keeps hashing cheap to start. Argon2id itself uses
+	// every byte, so this is not a truncation point.
+	MaxPasswordBytes = 1024
+)
+
+// commonPasswords are rejected outright. Length alone doesn't stop these.
+var commonPasswords = map[string]bool{
+	"1234567890":       true,
+	"0123456789":       true,
+	"1111111111":       true,
+	"password123":      true,
+	"password1234":     true,
+	"passwordpassword": true,
+	"qwertyuiop":       true,
+	"qwerty12345":      true,
+	"iloveyou123":      true,
+	"letmein1234":      true,
+	"welcome1234":      true,
+	"abcdefghij":       true,
+	"chirpychirpy":     true,
+	"changeme123":      true,
+	"administrator":    true,
+}
+
+// CheckPasswordStrength returns an error describing why password is too weak
+// to use. userInputs are values such as the email and handle that must not
+// appear in it.
+func CheckPasswordStrength(password string, userInputs ...string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordBytes {
+		return fmt.Errorf("must be at most %d bytes", MaxPasswordBytes)
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("is too common")
+	}
+
+	first, _ := utf8.DecodeRuneInString(password)
+	if strings.Trim(password, string(first)) == "" {
+		return errors.New("must not repeat a single character")
+	}
+
+	for _, input := range userInputs {
+		input, _, _ = strings.Cut(strings.ToLower(input), "@")
+		if len(input) >= 4 && strings.Contains(lower, input) {
+			return errors.New("must not contain your email or handle")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		userInputs []string
+		wantErr    bool
+	}{
+		{
+			name:     "Strong password",
+			password: "correct horse battery staple",
+			wantErr:  false,
+		},
+		{
+			name:     "Too short",
+			password: "Sh0rt!",
+			wantErr:  true,
+		},
+		{
+			name:     "Too long",
+			password: strings.Repeat("ab", 520),
+			wantErr:  true,
+		},
+		{
+			name:     "Common password",
+			password: "Password123",
+			wantErr:  true,
+		},
+		{
+			name:     "Repeated character",
+			password: "aaaaaaaaaaaa",
+			wantErr:  true,
+		},
+		{
+			name:       "Contains email",
+			password:   "alice-rocks-2024",
+			userInputs: []string{"Alice@example.com"},
+			wantErr:    true,
+		},
+		{
+			name:       "Contains handle",
+			password:   "i am chirper99 ok",
+			userInputs: []string{"chirper99"},
+			wantErr:    true,
+		},
+		{
+			name:       "Short inputs are ignored",
+			password:   "bob is my uncle",
+			userInputs: []string{"bob@example.com"},
+			wantErr:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPasswordStrength(tt.password, tt.userInputs...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckPasswordStrength() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
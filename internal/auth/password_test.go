@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams keep the tests fast.
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func newTestHasher(t *testing.T, params Argon2idParams) *PasswordHasher {
+	t.Helper()
+	h, err := NewPasswordHasher(params)
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() error = %v", err)
+	}
+	return h
+}
+
+func TestPasswordHasherCheck(t *testing.T) {
+	h := newTestHasher(t, testArgon2idParams)
+
+	argonHash, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+
+	// bcrypt would treat these two as the same password.
+	long := strings.Repeat("a", 72)
+	longHash, err := h.Hash(long + "1")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		wantErr  bool
+	}{
+		{"Argon2id match", "correct horse battery staple", argonHash, false},
+		{"Argon2id mismatch", "wrong", argonHash, true},
+		{"Bcrypt match", "correct horse battery staple", string(bcryptHash), false},
+		{"Bcrypt mismatch", "wrong", string(bcryptHash), true},
+		{"Long password match", long + "1", longHash, false},
+		{"No truncation past 72 bytes", long + "2", longHash, true},
+		{"Unknown algorithm", "x", "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA", true},
+		{"Malformed argon2id", "x", "$argon2id$v=19$m=0,t=0,p=0$$", true},
+		{"Empty hash", "x", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.Check(tt.password, tt.hash)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordHasherHashFormat(t *testing.T) {
+	h := newTestHasher(t, testArgon2idParams)
+
+	hash, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Hash() = %s, want a PHC argon2id string", hash)
+	}
+
+	other, _ := h.Hash("correct horse battery staple")
+	if other == hash {
+		t.Errorf("Hash() returned the same hash twice, salt is not random")
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	h := newTestHasher(t, testArgon2idParams)
+	current, _ := h.Hash("correct horse battery staple")
+
+	stronger := testArgon2idParams
+	stronger.Iterations = 2
+	outdated, _ := newTestHasher(t, stronger).Hash("correct horse battery staple")
+
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), bcrypt.MinCost)
+
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{"Current parameters", current, false},
+		{"Other parameters", outdated, true},
+		{"Bcrypt", string(bcryptHash), true},
+		{"Garbage", "garbage", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPasswordHasherRejectsZeroParams(t *testing.T) {
+	params := testArgon2idParams
+	params.Iterations = 0
+	if _, err := NewPasswordHasher(params); err == nil {
+		t.Errorf("NewPasswordHasher() accepted zero iterations")
+	}
+}
+
+func TestPasswordHasherBusy(t *testing.T) {
+	h := newTestHasher(t, testArgon2idParams)
+	hash, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	h.slots = make(chan struct{}, 1)
+	h.slotWait = 10 * time.Millisecond
+	h.slots <- struct{}{}
+
+	if _, err := h.Hash("correct horse battery staple"); !errors.Is(err, ErrHasherBusy) {
+		t.Errorf("Hash() error = %v, want ErrHasherBusy", err)
+	}
+	if err := h.Check("correct horse battery staple", hash); !errors.Is(err, ErrHasherBusy) {
+		t.Errorf("Check() error = %v, want ErrHasherBusy", err)
+	}
+	if err := h.CheckDummy("correct horse battery staple"); !errors.Is(err, ErrHasherBusy) {
+		t.Errorf("CheckDummy() error = %v, want ErrHasherBusy", err)
+	}
+
+	<-h.slots
+	if err := h.Check("correct horse battery staple", hash); err != nil {
+		t.Errorf("Check() error = %v after a slot was freed", err)
+	}
+	if err := h.CheckDummy("correct horse battery staple"); err == nil || errors.Is(err, ErrHasherBusy) {
+		t.Errorf("CheckDummy() error = %v, want a mismatch", err)
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Scope limits what a personal access token may do. Access tokens from a
+// login carry every scope.
+type Scope string
+
+const (
+	ScopeChirpsWrite  Scope = "chirps:write"
+	ScopeChirpsDelete Scope = "chirps:delete"
+	ScopeProfileRead  Scope = "profile:read"
+	ScopeProfileWrite Scope = "profile:write"
+)
+
+// Scopes lists every scope a personal access token can be granted.
+var Scopes = []Scope{
+	ScopeChirpsWrite,
+	ScopeChirpsDelete,
+	ScopeProfileRead,
+	ScopeProfileWrite,
+}
+
+// ValidScope reports whether s names a known scope.
+func ValidScope(s string) bool {
+	for _, scope := range Scopes {
+		if string(scope) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether granted includes want.
+func HasScope(granted []string, want Scope) bool {
+	for _, scope := range granted {
+		if scope == string(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// personalAccessTokenPrefix tells personal access tokens apart from JWTs,
+// which never contain an underscore before the first dot.
+const personalAccessTokenPrefix = "chirpy_pat_"
+
+// MakePersonalAccessToken returns a new random token. Only its HashToken
+// digest should be stored.
+func MakePersonalAccessToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return personalAccessTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// IsPersonalAccessToken reports whether a bearer token is a personal access
+// token rather than a JWT.
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, personalAccessTokenPrefix)
+}
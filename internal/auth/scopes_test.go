@@ -0,0 +1,57 @@
+package auth
+
+import "testing"
+
+func TestValidScope(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{"chirps:write", true},
+		{"chirps:delete", true},
+		{"profile:read", true},
+		{"profile:write", true},
+		{"chirps:*", false},
+		{"CHIRPS:WRITE", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			if got := ValidScope(tt.scope); got != tt.want {
+				t.Errorf("ValidScope(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	granted := []string{"chirps:write", "profile:read"}
+	if !HasScope(granted, ScopeChirpsWrite) {
+		t.Errorf("HasScope() = false for a granted scope")
+	}
+	if HasScope(granted, ScopeChirpsDelete) {
+		t.Errorf("HasScope() = true for a scope that wasn't granted")
+	}
+	if HasScope(nil, ScopeProfileRead) {
+		t.Errorf("HasScope() = true with no scopes granted")
+	}
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("MakePersonalAccessToken() error = %v", err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("IsPersonalAccessToken(%q) = false", token)
+	}
+
+	jwt, err := MakeJWT([16]byte{1}, newTestKeyring(t, "k", nil), 0)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+	if IsPersonalAccessToken(jwt) {
+		t.Errorf("IsPersonalAccessToken() = true for a JWT")
+	}
+}
@@ -7,15 +7,101 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const chirpIsReferenced = `-- name: ChirpIsReferenced :one
+SELECT EXISTS (
+    SELECT 1
+    FROM chirps
+    WHERE in_reply_to = $1::uuid OR rechirp_of = $1::uuid
+) AS is_referenced
+`
+
+func (q *Queries) ChirpIsReferenced(ctx context.Context, id uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, chirpIsReferenced, id)
+	var is_referenced bool
+	err := row.Scan(&is_referenced)
+	return is_referenced, err
+}
+
+const countChirpsByUserSince = `-- name: CountChirpsByUserSince :one
+SELECT COUNT(*) AS chirp_count,
+       COALESCE(MIN(created_at), $1::timestamp)::timestamp AS oldest_created_at
+FROM chirps
+WHERE user_id = $2 AND created_at >= $1::timestamp
+`
+
+type CountChirpsByUserSinceParams struct {
+	Since  time.Time
+	UserID uuid.UUID
+}
+
+type CountChirpsByUserSinceRow struct {
+	ChirpCount      int64
+	OldestCreatedAt time.Time
+}
+
+// Tombstoned chirps still count, and chirps are tombstoned rather than
+// deleted while they count, so deleting chirps doesn't free up room under
+// the hourly limit. oldest_created_at is when the first counted chirp was
+// posted, or since when there is none.
+func (q *Queries) CountChirpsByUserSince(ctx context.Context, arg CountChirpsByUserSinceParams) (CountChirpsByUserSinceRow, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByUserSince, arg.Since, arg.UserID)
+	var i CountChirpsByUserSinceRow
+	err := row.Scan(
+		&i.ChirpCount,
+		&i.OldestCreatedAt,
+	)
+	return i, err
+}
+
+const countRepliesByChirpIDs = `-- name: CountRepliesByChirpIDs :many
+SELECT in_reply_to, COUNT(*) AS reply_count
+FROM chirps
+WHERE in_reply_to = ANY($1::uuid[]) AND deleted_at IS NULL
+GROUP BY in_reply_to
+`
+
+type CountRepliesByChirpIDsRow struct {
+	InReplyTo  uuid.NullUUID
+	ReplyCount int64
+}
+
+func (q *Queries) CountRepliesByChirpIDs(ctx context.Context, chirpIds []uuid.UUID) ([]CountRepliesByChirpIDsRow, error) {
+	rows, err := q.db.QueryContext(ctx, countRepliesByChirpIDs, pq.Array(chirpIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountRepliesByChirpIDsRow
+	for rows.Next() {
+		var i CountRepliesByChirpIDsRow
+		if err := rows.Scan(
+			&i.InReplyTo,
+			&i.ReplyCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, created_at, updated_at, body, user_id
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, kind, rechirp_of)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
 `
 
 type CreateChirpParams struct {
@@ -24,6 +110,9 @@ type CreateChirpParams struct {
 	UpdatedAt time.Time
 	Body      string
 	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	Kind      string
+	RechirpOf uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
@@ -33,6 +122,9 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		arg.UpdatedAt,
 		arg.Body,
 		arg.UserID,
+		arg.InReplyTo,
+		arg.Kind,
+		arg.RechirpOf,
 	)
 	var i Chirp
 	err := row.Scan(
@@ -41,6 +133,11 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.RechirpOf,
+		&i.SearchVector,
 	)
 	return i, err
 }
@@ -57,7 +154,7 @@ func (q *Queries) DeleteChirpByID(ctx context.Context, id uuid.UUID) error {
 }
 
 const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
 FROM chirps
 WHERE id = $1
 `
@@ -71,13 +168,19 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.RechirpOf,
+		&i.SearchVector,
 	)
 	return i, err
 }
 
 const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
 FROM chirps
+WHERE deleted_at IS NULL
 ORDER BY created_at
 `
 
@@ -96,6 +199,11 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
 		); err != nil {
 			return nil, err
 		}
@@ -111,9 +219,9 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpsByUserID = `-- name: GetChirpsByUserID :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
 FROM chirps
-WHERE user_id = $1
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY created_at
 `
 
@@ -132,6 +240,68 @@ func (q *Queries) GetChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
+FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1::uuid)
+  AND ($2::timestamp IS NULL
+       OR (created_at, id) > ($2::timestamp, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type ListChirpsAscParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
 		); err != nil {
 			return nil, err
 		}
@@ -145,3 +315,402 @@ func (q *Queries) GetChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const listChirpsByIDs = `-- name: ListChirpsByIDs :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
+FROM chirps
+WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) ListChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
+FROM chirps
+WHERE deleted_at IS NULL
+  AND ($1::uuid IS NULL OR user_id = $1::uuid)
+  AND ($2::timestamp IS NULL
+       OR (created_at, id) < ($2::timestamp, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListChirpsDescParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRepliesByParentIDs = `-- name: ListRepliesByParentIDs :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
+FROM chirps
+WHERE in_reply_to = ANY($1::uuid[])
+ORDER BY created_at ASC, id ASC
+`
+
+func (q *Queries) ListRepliesByParentIDs(ctx context.Context, parentIds []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listRepliesByParentIDs, pq.Array(parentIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTimelineChirps = `-- name: ListTimelineChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.kind, chirps.rechirp_of, chirps.search_vector
+FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = $1
+  AND chirps.deleted_at IS NULL
+  AND ($2::timestamp IS NULL
+       OR (chirps.created_at, chirps.id) < ($2::timestamp, $3::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4
+`
+
+type ListTimelineChirpsParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+func (q *Queries) ListTimelineChirps(ctx context.Context, arg ListTimelineChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listTimelineChirps,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockChirp = `-- name: LockChirp :one
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
+FROM chirps
+WHERE id = $1
+FOR UPDATE
+`
+
+// Holds the row until the transaction ends so concurrent edits of the same
+// chirp are applied one after the other.
+func (q *Queries) LockChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, lockChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.RechirpOf,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.in_reply_to, chirps.deleted_at, chirps.kind, chirps.rechirp_of, chirps.search_vector, ts_rank(search_vector, to_tsquery('english', $1::text)) AS rank
+FROM chirps
+WHERE search_vector @@ to_tsquery('english', $1::text)
+  AND deleted_at IS NULL
+  AND ($2::uuid IS NULL OR user_id = $2::uuid)
+  AND ($3::timestamp IS NULL OR created_at >= $3::timestamp)
+  AND ($4::timestamp IS NULL OR created_at < $4::timestamp)
+  AND ($5::real IS NULL
+       OR (ts_rank(search_vector, to_tsquery('english', $1::text)), created_at, id)
+          < ($5::real, $6::timestamp, $7::uuid))
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT $8
+`
+
+type SearchChirpsParams struct {
+	Query           string
+	AuthorID        uuid.NullUUID
+	Since           sql.NullTime
+	Until           sql.NullTime
+	CursorRank      sql.NullFloat64
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+type SearchChirpsRow struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.UUID
+	InReplyTo    uuid.NullUUID
+	DeletedAt    sql.NullTime
+	Kind         string
+	RechirpOf    uuid.NullUUID
+	SearchVector interface{}
+	Rank         float32
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.CursorRank,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Kind,
+			&i.RechirpOf,
+			&i.SearchVector,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const shareLockChirp = `-- name: ShareLockChirp :one
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
+FROM chirps
+WHERE id = $1
+FOR SHARE
+`
+
+// Keeps the chirp from being deleted until the transaction ends, so a reply
+// or rechirp created against it is seen by a concurrent delete.
+func (q *Queries) ShareLockChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, shareLockChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.RechirpOf,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = $3
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, kind, rechirp_of, search_vector
+`
+
+type UpdateChirpBodyParams struct {
+	ID        uuid.UUID
+	Body      string
+	UpdatedAt time.Time
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body, arg.UpdatedAt)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.Kind,
+		&i.RechirpOf,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const userHasRechirped = `-- name: UserHasRechirped :one
+SELECT EXISTS (
+    SELECT 1
+    FROM chirps
+    WHERE user_id = $1::uuid
+      AND rechirp_of = $2::uuid
+      AND kind = 'rechirp'
+      AND deleted_at IS NULL
+) AS has_rechirped
+`
+
+type UserHasRechirpedParams struct {
+	UserID  uuid.UUID
+	ChirpID uuid.UUID
+}
+
+func (q *Queries) UserHasRechirped(ctx context.Context, arg UserHasRechirpedParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, userHasRechirped, arg.UserID, arg.ChirpID)
+	var has_rechirped bool
+	err := row.Scan(&has_rechirped)
+	return has_rechirped, err
+}
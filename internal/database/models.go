@@ -6,33 +6,155 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ChirpHashtag struct {
+	ChirpID   uuid.UUID
+	HashtagID uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpMention struct {
+	ChirpID     uuid.UUID
+	UserID      uuid.UUID
+	StartOffset int32
+	EndOffset   int32
+	CreatedAt   time.Time
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Body       string
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
 type Chirp struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.UUID
+	InReplyTo    uuid.NullUUID
+	DeletedAt    sql.NullTime
+	Kind         string
+	RechirpOf    uuid.NullUUID
+	SearchVector interface{}
+}
+
+type EmailVerificationToken struct {
 	ID        uuid.UUID
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
 	UserID    uuid.UUID
+	Email     string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
 }
 
-type RefreshToken struct {
-	Token     string
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type Hashtag struct {
+	ID        uuid.UUID
+	Tag       string
+	CreatedAt time.Time
+}
+
+type Like struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
+}
+
+type PasswordResetToken struct {
+	ID        uuid.UUID
 	UserID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	FamilyID   uuid.UUID
+	ReplacedBy sql.NullString
+	UserAgent  string
+	IpAddress  string
+	LastUsedAt time.Time
+}
+
+type Subscription struct {
+	UserID             uuid.UUID
+	Plan               string
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	EndedAt            sql.NullTime
+	Legacy             bool
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	HashedPassword  string
+	Handle          sql.NullString
+	DisplayName     string
+	Bio             string
+	AvatarUrl       string
+	TotpSecret      sql.NullString
+	TotpEnabled     bool
+	TotpLastStep    int64
+	EmailVerifiedAt sql.NullTime
+	PendingEmail    sql.NullString
+	DeleteAfter     sql.NullTime
+}
+
+type WebhookEvent struct {
+	ID          string
+	Event       string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int32
+	LastError   sql.NullString
+	ReceivedAt  time.Time
+	ProcessedAt sql.NullTime
 }
@@ -7,15 +7,89 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :one
+UPDATE users
+SET delete_after = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, cancelUserDeletion, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const confirmPendingEmail = `-- name: ConfirmPendingEmail :one
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified_at = $1, updated_at = NOW()
+WHERE id = $2 AND pending_email = $3::text
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+`
+
+type ConfirmPendingEmailParams struct {
+	EmailVerifiedAt sql.NullTime
+	ID              uuid.UUID
+	PendingEmail    string
+}
+
+// Matches nothing if the pending email changed after the verification was
+// sent.
+func (q *Queries) ConfirmPendingEmail(ctx context.Context, arg ConfirmPendingEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, confirmPendingEmail,
+		arg.EmailVerifiedAt,
+		arg.ID,
+		arg.PendingEmail,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
 `
 
 type CreateUserParams struct {
@@ -24,6 +98,10 @@ type CreateUserParams struct {
 	UpdatedAt      time.Time
 	Email          string
 	HashedPassword string
+	Handle         sql.NullString
+	DisplayName    string
+	Bio            string
+	AvatarUrl      string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
@@ -33,6 +111,10 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		arg.UpdatedAt,
 		arg.Email,
 		arg.HashedPassword,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+		arg.AvatarUrl,
 	)
 	var i User
 	err := row.Scan(
@@ -41,11 +123,43 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
 
+const deleteScheduledUsers = `-- name: DeleteScheduledUsers :execrows
+DELETE FROM users
+WHERE delete_after <= $1::timestamp
+`
+
+func (q *Queries) DeleteScheduledUsers(ctx context.Context, now time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteScheduledUsers, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUser, id)
+	return err
+}
+
 const deleteUsers = `-- name: DeleteUsers :exec
 DELETE FROM users
 `
@@ -55,8 +169,35 @@ func (q *Queries) DeleteUsers(ctx context.Context) error {
 	return err
 }
 
+const disableTOTP = `-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, disableTOTP, id)
+	return err
+}
+
+const enableTOTP = `-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled = TRUE, totp_last_step = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type EnableTOTPParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+func (q *Queries) EnableTOTP(ctx context.Context, arg EnableTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableTOTP, arg.ID, arg.TotpLastStep)
+	return err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SELECT id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
 FROM users
 WHERE email = $1
 `
@@ -70,20 +211,250 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+FROM users
+WHERE LOWER(handle) = LOWER($1::text)
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, handle string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const getUserProfileCounts = `-- name: GetUserProfileCounts :one
+SELECT
+    (SELECT COUNT(*) FROM chirps WHERE chirps.user_id = $1::uuid AND chirps.deleted_at IS NULL) AS chirp_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.followee_id = $1::uuid) AS follower_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.follower_id = $1::uuid) AS following_count
+`
+
+type GetUserProfileCountsRow struct {
+	ChirpCount     int64
+	FollowerCount  int64
+	FollowingCount int64
+}
+
+func (q *Queries) GetUserProfileCounts(ctx context.Context, userID uuid.UUID) (GetUserProfileCountsRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserProfileCounts, userID)
+	var i GetUserProfileCountsRow
+	err := row.Scan(
+		&i.ChirpCount,
+		&i.FollowerCount,
+		&i.FollowingCount,
+	)
+	return i, err
+}
+
+const getUsersByHandles = `-- name: GetUsersByHandles :many
+SELECT id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+FROM users
+WHERE LOWER(handle) = ANY($1::text[])
+`
+
+func (q *Queries) GetUsersByHandles(ctx context.Context, handles []string) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByHandles, pq.Array(handles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.AvatarUrl,
+			&i.TotpSecret,
+			&i.TotpEnabled,
+			&i.TotpLastStep,
+			&i.EmailVerifiedAt,
+			&i.PendingEmail,
+			&i.DeleteAfter,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPlaintextTOTPSecrets = `-- name: ListPlaintextTOTPSecrets :many
+SELECT id, totp_secret::text AS totp_secret
+FROM users
+WHERE totp_secret IS NOT NULL AND totp_secret NOT LIKE 'v1.%'
+`
+
+type ListPlaintextTOTPSecretsRow struct {
+	ID         uuid.UUID
+	TotpSecret string
+}
+
+// Secrets stored before they were encrypted. Sealed ones start with "v1.".
+func (q *Queries) ListPlaintextTOTPSecrets(ctx context.Context) ([]ListPlaintextTOTPSecretsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listPlaintextTOTPSecrets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPlaintextTOTPSecretsRow
+	for rows.Next() {
+		var i ListPlaintextTOTPSecretsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.TotpSecret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUser = `-- name: LockUser :exec
+SELECT id
+FROM users
+WHERE id = $1
+FOR UPDATE
+`
+
+// Holds the user's row until the transaction ends so checks against what
+// the user has already done, such as the hourly chirp limit, can't race.
+func (q *Queries) LockUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUser, id)
+	return err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :one
+UPDATE users
+SET email_verified_at = $1, updated_at = NOW()
+WHERE id = $2 AND email = $3
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+`
+
+type MarkEmailVerifiedParams struct {
+	EmailVerifiedAt sql.NullTime
+	ID              uuid.UUID
+	Email           string
+}
+
+// Matches nothing if the email changed after the verification was sent.
+func (q *Queries) MarkEmailVerified(ctx context.Context, arg MarkEmailVerifiedParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, markEmailVerified, arg.EmailVerifiedAt, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
 
-const setChirpyRed = `-- name: SetChirpyRed :one
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :one
 UPDATE users
-SET is_chirpy_red = TRUE
+SET delete_after = $2, updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
 `
 
-func (q *Queries) SetChirpyRed(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRowContext(ctx, setChirpyRed, id)
+type ScheduleUserDeletionParams struct {
+	ID          uuid.UUID
+	DeleteAfter sql.NullTime
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, scheduleUserDeletion, arg.ID, arg.DeleteAfter)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -91,26 +462,162 @@ func (q *Queries) SetChirpyRed(ctx context.Context, id uuid.UUID) (User, error)
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
 
-const updateUser = `-- name: UpdateUser :one
+const sealTOTPSecret = `-- name: SealTOTPSecret :exec
+UPDATE users
+SET totp_secret = $1::text
+WHERE id = $2::uuid AND totp_secret = $3::text
+`
+
+type SealTOTPSecretParams struct {
+	SealedSecret string
+	ID           uuid.UUID
+	PlainSecret  string
+}
+
+// Replaces a plaintext secret with its sealed form. Does nothing when the
+// secret changed since it was read.
+func (q *Queries) SealTOTPSecret(ctx context.Context, arg SealTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, sealTOTPSecret, arg.SealedSecret, arg.ID, arg.PlainSecret)
+	return err
+}
+
+const setPendingEmail = `-- name: SetPendingEmail :one
 UPDATE users
-SET email = $2, hashed_password = $3
+SET pending_email = $2, updated_at = NOW()
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
 `
 
-type UpdateUserParams struct {
+type SetPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetPendingEmail(ctx context.Context, arg SetPendingEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setPendingEmail, arg.ID, arg.PendingEmail)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const setPendingTOTPSecret = `-- name: SetPendingTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled = FALSE, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetPendingTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) SetPendingTOTPSecret(ctx context.Context, arg SetPendingTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setPendingTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const setUserHandle = `-- name: SetUserHandle :one
+UPDATE users
+SET handle = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+`
+
+type SetUserHandleParams struct {
+	ID     uuid.UUID
+	Handle sql.NullString
+}
+
+func (q *Queries) SetUserHandle(ctx context.Context, arg SetUserHandleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserHandle, arg.ID, arg.Handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
 	ID             uuid.UUID
-	Email          string
 	HashedPassword string
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET display_name = $2, bio = $3, avatar_url = $4, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url, totp_secret, totp_enabled, totp_last_step, email_verified_at, pending_email, delete_after
+`
+
+type UpdateUserProfileParams struct {
+	ID          uuid.UUID
+	DisplayName string
+	Bio         string
+	AvatarUrl   string
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.ID,
+		arg.DisplayName,
+		arg.Bio,
+		arg.AvatarUrl,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -118,7 +625,37 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.DeleteAfter,
 	)
 	return i, err
 }
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2
+`
+
+type UseTOTPStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+// Records the step of an accepted code. No rows are updated when a code from
+// that step or a later one was already used, which stops replays.
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useTOTPStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
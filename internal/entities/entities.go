@@ -0,0 +1,112 @@
+package entities
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxHashtagLength = 100
+	MaxHandleLength  = 30
+)
+
+// Mention is an @handle found in a chirp body. Start and End are offsets in
+// Unicode code points of the whole mention, @ included, End exclusive.
+type Mention struct {
+	Handle string
+	Start  int
+	End    int
+}
+
+// Hashtags returns the distinct, lowercased hashtags found in body in the
+// order they first appear. A hashtag is a # that starts a word, followed by
+// letters, digits or underscores and containing at least one letter.
+func Hashtags(body string) []string {
+	tags := []string{}
+	seen := map[string]struct{}{}
+	for _, token := range scan(body, '#') {
+		if utf8.RuneCountInString(token.Text) > maxHashtagLength || strings.IndexFunc(token.Text, unicode.IsLetter) < 0 {
+			continue
+		}
+		tag := strings.ToLower(token.Text)
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Mentions returns every @handle in body in the order they appear. The same
+// handle is reported once per occurrence.
+func Mentions(body string) []Mention {
+	mentions := []Mention{}
+	for _, token := range scan(body, '@') {
+		if !ValidHandle(token.Text) {
+			continue
+		}
+		mentions = append(mentions, Mention{
+			Handle: token.Text,
+			Start:  utf8.RuneCountInString(body[:token.Start]),
+			End:    utf8.RuneCountInString(body[:token.End]),
+		})
+	}
+	return mentions
+}
+
+// ValidHandle reports whether h is made only of ASCII letters, digits and
+// underscores and is at most MaxHandleLength long.
+func ValidHandle(h string) bool {
+	if h == "" || len(h) > MaxHandleLength {
+		return false
+	}
+	for _, r := range h {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+type token struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// scan finds every word in body introduced by sigil. Start and End are byte
+// offsets of the whole token, sigil included.
+func scan(body string, sigil rune) []token {
+	tokens := []token{}
+	prev := rune(-1)
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if r != sigil || isWordRune(prev) {
+			prev = r
+			i += size
+			continue
+		}
+
+		end := i + size
+		for end < len(body) {
+			next, nextSize := utf8.DecodeRuneInString(body[end:])
+			if !isWordRune(next) {
+				break
+			}
+			end += nextSize
+		}
+
+		if end > i+size {
+			tokens = append(tokens, token{Text: body[i+size : end], Start: i, End: end})
+		}
+		prev, _ = utf8.DecodeLastRuneInString(body[:end])
+		i = end
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,92 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "No hashtags",
+			body: "just a normal chirp",
+			want: []string{},
+		},
+		{
+			name: "Hashtags are lowercased and deduplicated",
+			body: "#Go is great, I love #go and #Postgres!",
+			want: []string{"go", "postgres"},
+		},
+		{
+			name: "Hashtag must start a word",
+			body: "email me at a#b or see #real",
+			want: []string{"real"},
+		},
+		{
+			name: "Purely numeric tags are ignored",
+			body: "we're #1 in #2024 #year2024",
+			want: []string{"year2024"},
+		},
+		{
+			name: "Unicode and underscores",
+			body: "#café_crème ##double #",
+			want: []string{"café_crème", "double"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Hashtags(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Hashtags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Mention
+	}{
+		{
+			name: "No mentions",
+			body: "hello world",
+			want: []Mention{},
+		},
+		{
+			name: "Mentions keep their case and offsets",
+			body: "hi @Alice and @bob_2!",
+			want: []Mention{
+				{Handle: "Alice", Start: 3, End: 9},
+				{Handle: "bob_2", Start: 14, End: 20},
+			},
+		},
+		{
+			name: "Email addresses are not mentions",
+			body: "mail me at me@example.com",
+			want: []Mention{},
+		},
+		{
+			name: "Offsets count code points",
+			body: "héllo @zoë @zoe",
+			want: []Mention{
+				{Handle: "zoe", Start: 11, End: 15},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Mentions(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mentions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Package entitlements maps each plan to what it lets a user do. Handlers ask
+// for a user's Entitlements instead of checking the plan themselves, so a new
+// plan or a changed limit only needs an edit here.
+package entitlements
+
+type Plan string
+
+const (
+	PlanFree      Plan = "free"
+	PlanChirpyRed Plan = "chirpy_red"
+)
+
+// Entitlements are the limits and features that come with a plan.
+type Entitlements struct {
+	Plan Plan `json:"plan"`
+	// MaxChirpLength is in bytes.
+	MaxChirpLength int `json:"max_chirp_length"`
+	// ChirpEditing allows changing the body of a chirp after posting it.
+	ChirpEditing bool `json:"chirp_editing"`
+	// ChirpsPerHour caps how many chirps, replies and rechirps can be posted
+	// in any hour.
+	ChirpsPerHour int `json:"chirps_per_hour"`
+}
+
+var plans = map[Plan]Entitlements{
+	PlanFree: {
+		Plan:           PlanFree,
+		MaxChirpLength: 140,
+		ChirpEditing:   true,
+		ChirpsPerHour:  30,
+	},
+	PlanChirpyRed: {
+		Plan:           PlanChirpyRed,
+		MaxChirpLength: 560,
+		ChirpEditing:   true,
+		ChirpsPerHour:  300,
+	},
+}
+
+// For returns the entitlements of plan. A plan this package doesn't know,
+// for example one that was retired, gets the free tier.
+func For(plan Plan) Entitlements {
+	e, ok := plans[plan]
+	if !ok {
+		return plans[PlanFree]
+	}
+	return e
+}
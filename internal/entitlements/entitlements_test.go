@@ -0,0 +1,42 @@
+package entitlements
+
+import "testing"
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		name string
+		plan Plan
+		want Plan
+	}{
+		{"Free", PlanFree, PlanFree},
+		{"Chirpy Red", PlanChirpyRed, PlanChirpyRed},
+		{"Unknown plan falls back to free", Plan("gold"), PlanFree},
+		{"Empty plan falls back to free", Plan(""), PlanFree},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := For(tt.plan).Plan; got != tt.want {
+				t.Errorf("For(%q).Plan = %q, want %q", tt.plan, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaidPlansAreNotWorseThanFree(t *testing.T) {
+	free := For(PlanFree)
+	for plan, e := range plans {
+		if e.Plan != plan {
+			t.Errorf("plans[%q].Plan = %q", plan, e.Plan)
+		}
+		if e.MaxChirpLength < free.MaxChirpLength {
+			t.Errorf("%s allows shorter chirps than the free tier", plan)
+		}
+		if e.ChirpsPerHour < free.ChirpsPerHour {
+			t.Errorf("%s allows fewer chirps per hour than the free tier", plan)
+		}
+		if free.ChirpEditing && !e.ChirpEditing {
+			t.Errorf("%s can't edit chirps but the free tier can", plan)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package lockout throttles repeated failures, such as wrong passwords, per
+// key. Each failure past a free allowance doubles the wait before the next
+// attempt, and enough failures in a row lock the key out for a fixed time.
+// State is kept in memory and forgotten after a quiet period.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy configures how quickly a Limiter slows down and locks out a key.
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts. It
+	// doubles with every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold failures lock the key for LockoutDuration. Zero never
+	// locks it out.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// ResetAfter is how long a key must go without failures before its
+	// count starts over.
+	ResetAfter time.Duration
+}
+
+type entry struct {
+	failures   int
+	lastFailed time.Time
+	blockedTo  time.Time
+}
+
+// sweepEvery is how many failures are recorded between sweeps for keys
+// whose state has expired.
+const sweepEvery = 1024
+
+// Limiter tracks failures per key. It is safe for concurrent use.
+type Limiter struct {
+	policy Policy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*entry
+	failures int
+}
+
+func New(policy Policy) *Limiter {
+	return &Limiter{
+		policy:  policy,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+// Allow reports whether key may make an attempt now. When it may not, it
+// returns how long to wait.
+func (l *Limiter) Allow(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := l.entry(key, l.now())
+	if e == nil {
+		return 0, true
+	}
+	wait := e.blockedTo.Sub(l.now())
+	if wait > 0 {
+		return wait, false
+	}
+	return 0, true
+}
+
+// Failure records a failed attempt for key.
+func (l *Limiter) Failure(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e := l.entry(key, now)
+	if e == nil {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.lastFailed = now
+	e.blockedTo = now.Add(l.delay(e.failures))
+
+	l.failures++
+	if l.failures%sweepEvery == 0 {
+		l.sweep(now)
+	}
+}
+
+// Success forgets the failures recorded for key.
+func (l *Limiter) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+// entry returns the live state for key, dropping it if it has expired.
+func (l *Limiter) entry(key string, now time.Time) *entry {
+	e, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+	if l.expired(e, now) {
+		delete(l.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (l *Limiter) expired(e *entry, now time.Time) bool {
+	return now.Sub(e.lastFailed) > l.policy.ResetAfter && !now.Before(e.blockedTo)
+}
+
+func (l *Limiter) delay(failures int) time.Duration {
+	if l.policy.LockoutThreshold > 0 && failures >= l.policy.LockoutThreshold {
+		return l.policy.LockoutDuration
+	}
+
+	extra := failures - l.policy.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+
+	delay := l.policy.BaseDelay
+	for i := 1; i < extra && delay < l.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, l.policy.MaxDelay)
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestLimiter(policy Policy) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(policy)
+	l.now = clock.now
+	return l, clock
+}
+
+var testPolicy = Policy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  15 * time.Minute,
+	ResetAfter:       time.Hour,
+}
+
+func TestDelay(t *testing.T) {
+	l := New(testPolicy)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{9, 32 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := l.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestDelayCappedAtMax(t *testing.T) {
+	l := New(Policy{FreeAttempts: 0, BaseDelay: time.Second, MaxDelay: 10 * time.Second})
+	if got := l.delay(100); got != 10*time.Second {
+		t.Errorf("delay(100) = %v, want %v", got, 10*time.Second)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	l, clock := newTestLimiter(testPolicy)
+
+	for range 3 {
+		if _, ok := l.Allow("a"); !ok {
+			t.Fatalf("Allow() blocked within the free attempts")
+		}
+		l.Failure("a")
+	}
+	if _, ok := l.Allow("a"); !ok {
+		t.Fatalf("Allow() blocked after exactly the free attempts")
+	}
+
+	l.Failure("a")
+	wait, ok := l.Allow("a")
+	if ok || wait != time.Second {
+		t.Fatalf("Allow() = %v, %v, want 1s, false", wait, ok)
+	}
+
+	if _, ok := l.Allow("b"); !ok {
+		t.Errorf("Allow() blocked an unrelated key")
+	}
+
+	clock.advance(time.Second)
+	if _, ok := l.Allow("a"); !ok {
+		t.Errorf("Allow() still blocked after the delay passed")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l, clock := newTestLimiter(testPolicy)
+
+	for range 10 {
+		l.Failure("a")
+	}
+	wait, ok := l.Allow("a")
+	if ok || wait != 15*time.Minute {
+		t.Fatalf("Allow() = %v, %v, want 15m, false", wait, ok)
+	}
+
+	clock.advance(15 * time.Minute)
+	if _, ok := l.Allow("a"); !ok {
+		t.Errorf("Allow() still blocked after the lockout ended")
+	}
+}
+
+func TestSuccessResets(t *testing.T) {
+	l, _ := newTestLimiter(testPolicy)
+
+	for range 5 {
+		l.Failure("a")
+	}
+	l.Success("a")
+	if _, ok := l.Allow("a"); !ok {
+		t.Fatalf("Allow() blocked after Success()")
+	}
+
+	l.Failure("a")
+	if _, ok := l.Allow("a"); !ok {
+		t.Errorf("Allow() blocked on the first failure after Success()")
+	}
+}
+
+func TestResetAfterQuietPeriod(t *testing.T) {
+	l, clock := newTestLimiter(testPolicy)
+
+	for range 3 {
+		l.Failure("a")
+	}
+	clock.advance(2 * time.Hour)
+
+	l.Failure("a")
+	if _, ok := l.Allow("a"); !ok {
+		t.Errorf("Allow() counted failures from before the quiet period")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l, clock := newTestLimiter(testPolicy)
+
+	l.Failure("old")
+	clock.advance(2 * time.Hour)
+	l.Failure("new")
+	l.sweep(clock.now())
+
+	if _, ok := l.entries["old"]; ok {
+		t.Errorf("sweep() kept an expired entry")
+	}
+	if _, ok := l.entries["new"]; !ok {
+		t.Errorf("sweep() dropped a live entry")
+	}
+}
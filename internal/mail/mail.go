@@ -0,0 +1,123 @@
+// Package mail sends the transactional emails Chirpy needs, such as password
+// reset links, through a pluggable Mailer.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer delivers messages through an SMTP server. From may include a
+// display name. Auth may be nil for servers that don't require it.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := netmail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+	return smtp.SendMail(m.Addr, m.Auth, from.Address, []string{msg.To}, data)
+}
+
+// FileMailer writes each message to its own .eml file in Dir instead of
+// sending it, for local development.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.NewString())
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
+
+// MemoryMailer keeps messages in memory so tests can inspect them.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	_, err := format("", msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns every message sent so far, oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// format renders msg as an RFC 5322 message. Header values containing line
+// breaks are rejected so user input can't add headers.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	for _, value := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("mail header contains a line break")
+		}
+	}
+	if msg.To == "" {
+		return nil, errors.New("mail has no recipient")
+	}
+
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
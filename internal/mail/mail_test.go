@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		msg     Message
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Plain message",
+			msg:  Message{To: "alice@example.com", Subject: "Hi", Body: "line one\nline two"},
+			want: []string{
+				"From: Chirpy <no-reply@chirpy.test>\r\n",
+				"To: alice@example.com\r\n",
+				"Subject: Hi\r\n",
+				"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n",
+				"\r\n\r\nline one\r\nline two",
+			},
+		},
+		{
+			name:    "Header injection in subject",
+			msg:     Message{To: "alice@example.com", Subject: "Hi\r\nBcc: eve@example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "Header injection in recipient",
+			msg:     Message{To: "alice@example.com\nBcc: eve@example.com", Subject: "Hi"},
+			wantErr: true,
+		},
+		{
+			name:    "No recipient",
+			msg:     Message{Subject: "Hi"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := format("Chirpy <no-reply@chirpy.test>", tt.msg, date)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("format() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(got), want) {
+					t.Errorf("format() = %q, missing %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	msg := Message{To: "alice@example.com", Subject: "Hi", Body: "Hello"}
+
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := m.Send(context.Background(), Message{To: "bad\n", Subject: "Hi"}); err == nil {
+		t.Errorf("Send() accepted an invalid message")
+	}
+
+	got := m.Messages()
+	if len(got) != 1 || got[0] != msg {
+		t.Errorf("Messages() = %+v, want [%+v]", got, msg)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &FileMailer{Dir: dir, From: "no-reply@chirpy.test"}
+
+	err := m.Send(context.Background(), Message{To: "alice@example.com", Subject: "Hi", Body: "Hello"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one .eml file, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(data), "To: alice@example.com\r\n") || !strings.HasSuffix(string(data), "Hello") {
+		t.Errorf("unexpected message file:\n%s", data)
+	}
+}
@@ -0,0 +1,101 @@
+package polka
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("whsec_test")
+	otherSecret := []byte("whsec_other")
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	signedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	valid := Sign(secret, body, signedAt)
+
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:   "Valid signature",
+			header: valid,
+			body:   body,
+			now:    signedAt.Add(time.Minute),
+		},
+		{
+			name:   "One of several signatures matches",
+			header: Sign(otherSecret, body, signedAt) + "," + strings.TrimPrefix(valid, "t=1704110400,"),
+			body:   body,
+			now:    signedAt,
+		},
+		{
+			name:   "Unknown schemes are ignored",
+			header: valid + ",v0=abc",
+			body:   body,
+			now:    signedAt,
+		},
+		{
+			name:    "Missing header",
+			header:  "",
+			body:    body,
+			now:     signedAt,
+			wantErr: ErrMissingSignature,
+		},
+		{
+			name:    "No v1 signature",
+			header:  "t=1704110400,v0=abc",
+			body:    body,
+			now:     signedAt,
+			wantErr: ErrMissingSignature,
+		},
+		{
+			name:    "Tampered body",
+			header:  valid,
+			body:    []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"00000000-0000-0000-0000-000000000000"}}`),
+			now:     signedAt,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "Wrong secret",
+			header:  Sign(otherSecret, body, signedAt),
+			body:    body,
+			now:     signedAt,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "Malformed signature",
+			header:  "t=1704110400,v1=not-hex",
+			body:    body,
+			now:     signedAt,
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "Too old",
+			header:  valid,
+			body:    body,
+			now:     signedAt.Add(DefaultTolerance + time.Second),
+			wantErr: ErrStaleSignature,
+		},
+		{
+			name:    "Too far in the future",
+			header:  valid,
+			body:    body,
+			now:     signedAt.Add(-DefaultTolerance - time.Second),
+			wantErr: ErrStaleSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(secret, tt.header, tt.body, tt.now, DefaultTolerance)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
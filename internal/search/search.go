@@ -0,0 +1,123 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var ErrEmptyQuery = errors.New("search query is empty")
+
+// ParseQuery turns a user supplied search string into Postgres to_tsquery
+// syntax. Terms are ANDed together unless separated by OR. A quoted string is
+// matched as a phrase, a trailing * makes a term a prefix match and a leading
+// - excludes a term.
+func ParseQuery(q string) (string, error) {
+	clauses := []string{}
+	pendingOp := ""
+
+	for _, token := range tokenize(q) {
+		if token == "OR" {
+			if len(clauses) > 0 {
+				pendingOp = " | "
+			}
+			continue
+		}
+
+		negate := false
+		if strings.HasPrefix(token, "-") {
+			negate = true
+			token = token[1:]
+		}
+
+		var clause string
+		if strings.HasPrefix(token, `"`) {
+			clause = phrase(strings.Trim(token, `"`))
+		} else {
+			clause = term(token)
+		}
+		if clause == "" {
+			continue
+		}
+		if negate {
+			clause = "!" + clause
+		}
+
+		if len(clauses) > 0 {
+			if pendingOp == "" {
+				pendingOp = " & "
+			}
+			clause = pendingOp + clause
+		}
+		pendingOp = ""
+		clauses = append(clauses, clause)
+	}
+
+	if len(clauses) == 0 {
+		return "", ErrEmptyQuery
+	}
+	return strings.Join(clauses, ""), nil
+}
+
+// tokenize splits on whitespace while keeping quoted phrases together.
+func tokenize(q string) []string {
+	tokens := []string{}
+	var current strings.Builder
+	inQuote := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			current.WriteRune(r)
+			if inQuote {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func term(token string) string {
+	prefix := strings.HasSuffix(token, "*")
+	words := words(token)
+	if len(words) == 0 {
+		return ""
+	}
+	if prefix {
+		words[len(words)-1] += ":*"
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
+
+func phrase(text string) string {
+	words := words(text)
+	if len(words) == 0 {
+		return ""
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return "(" + strings.Join(words, " <-> ") + ")"
+}
+
+// words keeps only letters and digits so that user input can never inject
+// tsquery operators.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
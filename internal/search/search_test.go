@@ -0,0 +1,66 @@
+package search
+
+import "testing"
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Single term",
+			query: "golang",
+			want:  "golang",
+		},
+		{
+			name:  "Terms are ANDed",
+			query: "go postgres",
+			want:  "go & postgres",
+		},
+		{
+			name:  "Phrase",
+			query: `"hello big world"`,
+			want:  "(hello <-> big <-> world)",
+		},
+		{
+			name:  "Prefix",
+			query: "chir*",
+			want:  "chir:*",
+		},
+		{
+			name:  "OR and negation",
+			query: "cats OR dogs -birds",
+			want:  "cats | dogs & !birds",
+		},
+		{
+			name:  "Operators in input are stripped",
+			query: "a&b | !c",
+			want:  "(a <-> b) & c",
+		},
+		{
+			name:    "Empty query",
+			query:   "  ",
+			wantErr: true,
+		},
+		{
+			name:    "Only punctuation",
+			query:   "!!! ???",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseQuery() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
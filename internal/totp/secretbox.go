@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of a SecretBox key: AES-256.
+const KeySize = 32
+
+// sealedPrefix marks a stored secret as sealed and names the format, so the
+// format can change later without guessing what a value is.
+const sealedPrefix = "v1."
+
+var errInvalidSealedSecret = errors.New("invalid sealed secret")
+
+// SecretBox encrypts shared secrets before they are stored, so reading the
+// database isn't enough to generate codes. It uses AES-256-GCM; the
+// additional data passed to Seal and Open, such as the user ID, binds a
+// sealed secret to its owner so it can't be copied to another account.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts secret for storage.
+func (b *SecretBox) Seal(secret string, additionalData []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize(), b.aead.NonceSize()+len(secret)+b.aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(secret), additionalData)
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed with the same key and additional data.
+func (b *SecretBox) Open(sealed string, additionalData []byte) (string, error) {
+	if !IsSealed(sealed) {
+		return "", errInvalidSealedSecret
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil || len(data) < b.aead.NonceSize() {
+		return "", errInvalidSealedSecret
+	}
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	secret, err := b.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return "", errInvalidSealedSecret
+	}
+	return string(secret), nil
+}
+
+// IsSealed reports whether s was produced by Seal rather than being a
+// plaintext secret stored before secrets were encrypted.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, sealedPrefix)
+}
@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatalf("NewSecretBox() error = %v", err)
+	}
+	other, err := NewSecretBox(bytes.Repeat([]byte{2}, KeySize))
+	if err != nil {
+		t.Fatalf("NewSecretBox() error = %v", err)
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	ad := []byte("user-1")
+
+	sealed, err := box.Seal(secret, ad)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if strings.Contains(sealed, secret) || !IsSealed(sealed) {
+		t.Fatalf("Seal() = %q, want an opaque sealed value", sealed)
+	}
+
+	again, err := box.Seal(secret, ad)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if again == sealed {
+		t.Error("Seal() returned the same value twice")
+	}
+
+	got, err := box.Open(sealed, ad)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got != secret {
+		t.Errorf("Open() = %q, want %q", got, secret)
+	}
+
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+
+	tests := []struct {
+		name   string
+		box    *SecretBox
+		sealed string
+		ad     []byte
+	}{
+		{"Wrong key", other, sealed, ad},
+		{"Wrong additional data", box, sealed, []byte("user-2")},
+		{"Tampered", box, tampered, ad},
+		{"Plaintext", box, secret, ad},
+		{"Truncated", box, sealedPrefix + "AAAA", ad},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.box.Open(tt.sealed, tt.ad); err == nil {
+				t.Error("Open() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestNewSecretBoxKeySize(t *testing.T) {
+	for _, size := range []int{0, 16, 31, 33} {
+		if _, err := NewSecretBox(make([]byte, size)); err == nil {
+			t.Errorf("NewSecretBox(%d bytes) error = nil, want an error", size)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key from the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 column.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			step := Step(time.Unix(tt.unix, 0))
+			got := hotp([]byte("12345678901234567890"), uint64(step), 8)
+			if got != tt.want {
+				t.Errorf("hotp() at %d = %s, want %s", tt.unix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	got, err := Code(rfcSecret, time.Unix(59, 0))
+	if err != nil {
+		t.Fatalf("Code() error = %v", err)
+	}
+	if got != "287082" {
+		t.Errorf("Code() = %s, want 287082", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, now)
+	previous, _ := Code(rfcSecret, now.Add(-Period))
+	stale, _ := Code(rfcSecret, now.Add(-3*Period))
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantErr  bool
+	}{
+		{
+			name:     "Current code",
+			secret:   rfcSecret,
+			code:     code,
+			wantStep: Step(now),
+		},
+		{
+			name:     "Code with a space",
+			secret:   rfcSecret,
+			code:     code[:3] + " " + code[3:],
+			wantStep: Step(now),
+		},
+		{
+			name:     "Previous period within skew",
+			secret:   rfcSecret,
+			code:     previous,
+			wantStep: Step(now) - 1,
+		},
+		{
+			name:    "Stale code",
+			secret:  rfcSecret,
+			code:    stale,
+			wantErr: true,
+		},
+		{
+			name:    "Wrong length",
+			secret:  rfcSecret,
+			code:    "12345",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid secret",
+			secret:  "not base32!",
+			code:    code,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := Validate(tt.secret, tt.code, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if step != tt.wantStep {
+				t.Errorf("Validate() step = %d, want %d", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	now := time.Now()
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatalf("Code() error = %v", err)
+	}
+	if _, err := Validate(secret, code, now); err != nil {
+		t.Errorf("Validate() rejected a fresh code: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("JBSWY3DPEHPK3PXP", "Chirpy", "alice@example.com")
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("URI() is not a URL: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("URI() = %s, want an otpauth://totp URI", uri)
+	}
+	if !strings.HasPrefix(u.Path, "/Chirpy:alice@example.com") {
+		t.Errorf("URI() label = %s", u.Path)
+	}
+	q := u.Query()
+	if q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "Chirpy" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("URI() query = %s", u.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("GenerateRecoveryCodes() returned %d codes, want 10", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("GenerateRecoveryCodes() returned %s twice", code)
+		}
+		seen[code] = true
+
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if NormalizeRecoveryCode(typed) != NormalizeRecoveryCode(code) {
+			t.Errorf("NormalizeRecoveryCode(%q) != NormalizeRecoveryCode(%q)", typed, code)
+		}
+	}
+}
@@ -18,12 +18,26 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	type errorResponse struct {
 		Error string `json:"error"`
 	}
-	
+
 	respondWithJSON(w, code, errorResponse{
 		Error: msg,
 	})
 }
 
+// respondWithValidationErrors reports every invalid field of a request at
+// once, keyed by its JSON name.
+func respondWithValidationErrors(w http.ResponseWriter, fields map[string]string) {
+	type errorResponse struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+
+	respondWithJSON(w, http.StatusBadRequest, errorResponse{
+		Error:  "Validation failed",
+		Fields: fields,
+	})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	data, err := json.Marshal(payload)
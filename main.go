@@ -1,23 +1,58 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"log"
+	"net"
 	"net/http"
+	"net/smtp"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/trungdoanle1101/chirp/internal/auth"
 	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/lockout"
+	"github.com/trungdoanle1101/chirp/internal/mail"
+	"github.com/trungdoanle1101/chirp/internal/totp"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	jwtSecret      string
-	polkaKey       string
+	fileserverHits     atomic.Int32
+	db                 *database.Queries
+	sqlDB              *sql.DB
+	platform           string
+	keyring            *auth.Keyring
+	passwords          *auth.PasswordHasher
+	polkaWebhookSecret []byte
+	chirpEditWindow    time.Duration
+	mailer             mail.Mailer
+	appURL             string
+
+	// totpSecrets seals two-factor secrets before they are stored.
+	totpSecrets *totp.SecretBox
+
+	// adminAPIKey guards the webhook event endpoints under /admin. Empty
+	// disables them.
+	adminAPIKey string
+
+	// accountDeletionGracePeriod is how long a deleted account can still be
+	// recovered by logging in. Zero deletes it immediately.
+	accountDeletionGracePeriod time.Duration
+
+	// requireVerifiedEmail stops users who haven't confirmed their email
+	// address from posting chirps.
+	requireVerifiedEmail bool
+
+	loginAccountLimiter *lockout.Limiter
+	loginIPLimiter      *lockout.Limiter
+	emailLimiter        *lockout.Limiter
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -28,6 +63,68 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(handlerFunc)
 }
 
+// newMailer sends mail through SMTP_ADDR. On the dev platform it may be left
+// unset, and messages are written to MAIL_DIR so they can be read locally.
+// Anywhere else that would quietly keep password reset links from reaching
+// users, so it is fatal.
+func newMailer(platform string) mail.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@localhost>"
+	}
+
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		if platform != "dev" {
+			log.Fatal(`SMTP_ADDR must be set unless PLATFORM is "dev"`)
+		}
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "tmp/mail"
+		}
+		return &mail.FileMailer{Dir: dir, From: from}
+	}
+
+	var smtpAuth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			log.Fatalf("SMTP_ADDR must be host:port: %s", err)
+		}
+		smtpAuth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return &mail.SMTPMailer{Addr: addr, From: from, Auth: smtpAuth}
+}
+
+// argon2idParams starts from auth.DefaultArgon2idParams and applies
+// ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM. Raising them
+// later is safe: existing hashes are upgraded as their owners log in.
+func argon2idParams() auth.Argon2idParams {
+	params := auth.DefaultArgon2idParams
+	if memory := os.Getenv("ARGON2_MEMORY_KIB"); memory != "" {
+		n, err := strconv.ParseUint(memory, 10, 32)
+		if err != nil {
+			log.Fatalf("ARGON2_MEMORY_KIB must be a number: %s", err)
+		}
+		params.Memory = uint32(n)
+	}
+	if iterations := os.Getenv("ARGON2_ITERATIONS"); iterations != "" {
+		n, err := strconv.ParseUint(iterations, 10, 32)
+		if err != nil {
+			log.Fatalf("ARGON2_ITERATIONS must be a number: %s", err)
+		}
+		params.Iterations = uint32(n)
+	}
+	if parallelism := os.Getenv("ARGON2_PARALLELISM"); parallelism != "" {
+		n, err := strconv.ParseUint(parallelism, 10, 8)
+		if err != nil {
+			log.Fatalf("ARGON2_PARALLELISM must be a number: %s", err)
+		}
+		params.Parallelism = uint8(n)
+	}
+	return params
+}
+
 func main() {
 	const filepathRoot = "."
 	const port = "8080"
@@ -42,45 +139,165 @@ func main() {
 		log.Fatal("DB_URL must be set")
 	}
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Fatal("JWT_SECRET must be set")
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		log.Fatal("JWT_KEYS_DIR must be set")
+	}
+
+	keyring, err := auth.LoadKeyring(keysDir, os.Getenv("JWT_SIGNING_KEY_ID"))
+	if err != nil {
+		log.Fatalf("Unable to load JWT signing keys: %s", err)
+	}
+
+	// Keep accepting access tokens signed with the old shared secret until
+	// they have expired.
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keyring.AcceptLegacySecret(secret)
+	}
+
+	passwords, err := auth.NewPasswordHasher(argon2idParams())
+	if err != nil {
+		log.Fatalf("Invalid password hashing parameters: %s", err)
+	}
+
+	polkaWebhookSecret := os.Getenv("POLKA_WEBHOOK_SECRET")
+	if polkaWebhookSecret == "" {
+		log.Fatal("POLKA_WEBHOOK_SECRET must be set")
+	}
+
+	// A base64 encoded 32 byte key, for example from `openssl rand -base64 32`.
+	totpKey, err := base64.StdEncoding.DecodeString(os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatalf("TOTP_ENCRYPTION_KEY must be base64: %s", err)
+	}
+	totpSecrets, err := totp.NewSecretBox(totpKey)
+	if err != nil {
+		log.Fatalf("TOTP_ENCRYPTION_KEY must be set to a %d byte key: %s", totp.KeySize, err)
 	}
 
-	polkaKey := os.Getenv("POLKA_KEY")
-	if polkaKey == "" {
-		log.Fatal("POLKA_KEY must be set")
+	// Zero means chirps can be edited at any time.
+	var chirpEditWindow time.Duration
+	if window := os.Getenv("CHIRP_EDIT_WINDOW"); window != "" {
+		chirpEditWindow, err = time.ParseDuration(window)
+		if err != nil {
+			log.Fatalf("CHIRP_EDIT_WINDOW must be a duration: %s", err)
+		}
 	}
 
+	// Zero deletes accounts as soon as their owners ask.
+	accountDeletionGracePeriod := 30 * 24 * time.Hour
+	if grace := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); grace != "" {
+		accountDeletionGracePeriod, err = time.ParseDuration(grace)
+		if err != nil {
+			log.Fatalf("ACCOUNT_DELETION_GRACE_PERIOD must be a duration: %s", err)
+		}
+	}
+
+	requireVerifiedEmail := false
+	if require := os.Getenv("REQUIRE_VERIFIED_EMAIL"); require != "" {
+		requireVerifiedEmail, err = strconv.ParseBool(require)
+		if err != nil {
+			log.Fatalf("REQUIRE_VERIFIED_EMAIL must be a boolean: %s", err)
+		}
+	}
+
+	// Links in emails point here.
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:" + port
+	}
+
+	platform := os.Getenv("PLATFORM")
+
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
 		log.Fatalf("Unable to connect to the database %s", err)
 	}
 
 	apiCfg := &apiConfig{
-		fileserverHits: atomic.Int32{},
-		db:             database.New(db),
-		platform:       os.Getenv("PLATFORM"),
-		jwtSecret:      secret,
-		polkaKey:       polkaKey,
+		fileserverHits:     atomic.Int32{},
+		db:                 database.New(db),
+		sqlDB:              db,
+		platform:           platform,
+		keyring:            keyring,
+		passwords:          passwords,
+		polkaWebhookSecret: []byte(polkaWebhookSecret),
+		chirpEditWindow:    chirpEditWindow,
+		mailer:             newMailer(platform),
+		appURL:             strings.TrimSuffix(appURL, "/"),
+		totpSecrets:        totpSecrets,
+
+		adminAPIKey: os.Getenv("ADMIN_API_KEY"),
+
+		accountDeletionGracePeriod: accountDeletionGracePeriod,
+		requireVerifiedEmail:       requireVerifiedEmail,
+
+		loginAccountLimiter: lockout.New(loginAccountPolicy),
+		loginIPLimiter:      lockout.New(loginIPPolicy),
+		emailLimiter:        lockout.New(emailPolicy),
 	}
 
+	err = apiCfg.sealPlaintextTOTPSecrets(context.Background())
+	if err != nil {
+		log.Fatalf("Unable to encrypt two-factor secrets: %s", err)
+	}
+
+	go runEvery(context.Background(), time.Hour, apiCfg.purgeDeletedAccounts)
+	go runEvery(context.Background(), 10*time.Minute, apiCfg.expireSubscriptions)
+
 	mux := http.NewServeMux()
 	fileServerHandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot))))
 
 	mux.Handle("/app/", fileServerHandler)
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerSearchChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirpByID)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerUpdateChirp)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirpByID)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerGetChirpRevisions)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerGetChirpThread)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", apiCfg.handlerLikeChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", apiCfg.handlerUnlikeChirp)
+	mux.HandleFunc("GET /api/timeline", apiCfg.handlerGetTimeline)
+	mux.HandleFunc("GET /api/mentions", apiCfg.handlerGetMentions)
+	mux.HandleFunc("GET /api/hashtags/trending", apiCfg.handlerGetTrendingHashtags)
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiCfg.handlerGetHashtagChirps)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
+	mux.HandleFunc("PATCH /api/users/me", apiCfg.handlerPatchUser)
+	mux.HandleFunc("DELETE /api/users/me", apiCfg.handlerDeleteUser)
+	mux.HandleFunc("GET /api/users/me/export", apiCfg.handlerExportUser)
+	mux.HandleFunc("GET /api/users/me/entitlements", apiCfg.handlerGetEntitlements)
+	mux.HandleFunc("GET /api/users/{handleOrID}", apiCfg.handlerGetProfile)
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.handlerFollowUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.handlerUnfollowUser)
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerGetFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerGetFollowing)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/password-reset/request", apiCfg.handlerRequestPasswordReset)
+	mux.HandleFunc("POST /api/password-reset/confirm", apiCfg.handlerConfirmPasswordReset)
+	mux.HandleFunc("POST /api/email/verify", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/email/verify/resend", apiCfg.handlerResendVerificationEmail)
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.handlerLoginTwoFactor)
+	mux.HandleFunc("POST /api/2fa/enroll", apiCfg.handlerEnrollTwoFactor)
+	mux.HandleFunc("POST /api/2fa/verify", apiCfg.handlerVerifyTwoFactor)
+	mux.HandleFunc("DELETE /api/2fa", apiCfg.handlerDisableTwoFactor)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerGetSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerRevokeSession)
+	mux.HandleFunc("POST /api/logout-all", apiCfg.handlerLogoutAll)
+	mux.HandleFunc("POST /api/tokens", apiCfg.handlerCreatePersonalAccessToken)
+	mux.HandleFunc("GET /api/tokens", apiCfg.handlerGetPersonalAccessTokens)
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", apiCfg.handlerRevokePersonalAccessToken)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhooks)
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.HandleFunc("GET /admin/webhooks/events", apiCfg.handlerGetWebhookEvents)
+	mux.HandleFunc("GET /admin/webhooks/events/{eventID}", apiCfg.handlerGetWebhookEvent)
+	mux.HandleFunc("POST /admin/webhooks/events/{eventID}/replay", apiCfg.handlerReplayWebhookEvent)
 	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
 	server := &http.Server{
 		Handler: mux,
@@ -1,23 +1,133 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ChirpKind string
+
+const (
+	ChirpKindChirp   ChirpKind = "chirp"
+	ChirpKindRechirp ChirpKind = "rechirp"
+	ChirpKindQuote   ChirpKind = "quote"
+)
+
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Body       string     `json:"body"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Kind       ChirpKind  `json:"kind"`
+	InReplyTo  *uuid.UUID `json:"in_reply_to"`
+	RechirpOf  *Chirp     `json:"rechirp_of,omitempty"`
+	ReplyCount int64      `json:"reply_count"`
+	LikeCount  int64      `json:"like_count"`
+	LikedByMe  *bool      `json:"liked_by_me,omitempty"`
+	Mentions   []Mention  `json:"mentions"`
+	Deleted    bool       `json:"deleted,omitempty"`
+}
+
+// Mention links the code point range [Start, End) of a chirp body to the
+// user it names.
+type Mention struct {
+	UserID uuid.UUID `json:"user_id"`
+	Handle string    `json:"handle"`
+	Start  int       `json:"start"`
+	End    int       `json:"end"`
+}
+
+// ChirpThreadNode is a chirp together with the replies posted under it.
+type ChirpThreadNode struct {
+	Chirp
+	Replies []ChirpThreadNode `json:"replies"`
+}
+
+type ChirpThread struct {
+	Ancestors []Chirp         `json:"ancestors"`
+	Chirp     ChirpThreadNode `json:"chirp"`
 }
 
 type User struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Email            string     `json:"email"`
+	Handle           string     `json:"handle"`
+	DisplayName      string     `json:"display_name"`
+	Bio              string     `json:"bio"`
+	AvatarURL        string     `json:"avatar_url"`
+	IsChirpyRed      bool       `json:"is_chirpy_red"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	EmailVerified    bool       `json:"email_verified"`
+	PendingEmail     string     `json:"pending_email,omitempty"`
+	DeleteAfter      *time.Time `json:"delete_after,omitempty"`
+}
+
+// Profile is the public view of a user. It never includes the email.
+type Profile struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	Handle         string    `json:"handle"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	ChirpCount     int64     `json:"chirp_count"`
+	FollowerCount  int64     `json:"follower_count"`
+	FollowingCount int64     `json:"following_count"`
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID `json:"id"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type Follow struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TrendingHashtag struct {
+	Tag        string `json:"tag"`
+	ChirpCount int64  `json:"chirp_count"`
+}
+
+// Session is one logged-in device, backed by a refresh token family.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+// PersonalAccessToken describes a token a user created for automation. Token
+// holds the secret and is only filled in on the response that creates it.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+// WebhookEvent is a stored Polka webhook as shown on the admin API.
+type WebhookEvent struct {
+	ID          string          `json:"id"`
+	Event       string          `json:"event"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+	Payload     json.RawMessage `json:"payload"`
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+// pageCursor is the position of the last item of a page in keyset order.
+// It is handed to clients as an opaque string and echoed back via ?cursor=.
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+	// Rank is only set by listings ordered by relevance, such as search.
+	Rank *float32
+}
+
+func (c pageCursor) encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	if c.Rank != nil {
+		raw += "|" + strconv.FormatFloat(float64(*c.Rank), 'g', -1, 32)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 2 && len(parts) != 3 {
+		return pageCursor{}, errors.New("invalid cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return pageCursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	cursor := pageCursor{CreatedAt: createdAt, ID: id}
+	if len(parts) == 3 {
+		rank, err := strconv.ParseFloat(parts[2], 32)
+		if err != nil {
+			return pageCursor{}, fmt.Errorf("invalid cursor: %w", err)
+		}
+		rank32 := float32(rank)
+		cursor.Rank = &rank32
+	}
+
+	return cursor, nil
+}
+
+// parsePageParams reads ?limit= and ?cursor= from the query string.
+// A nil cursor means the first page was requested.
+func parsePageParams(query url.Values) (int, *pageCursor, error) {
+	limit := defaultPageLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return 0, nil, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		limit = n
+	}
+
+	cursorStr := query.Get("cursor")
+	if cursorStr == "" {
+		return limit, nil, nil
+	}
+
+	cursor, err := decodeCursor(cursorStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, &cursor, nil
+}
+
+// cursorArgs converts an optional cursor into the nullable keyset
+// arguments taken by the List* queries.
+func cursorArgs(cursor *pageCursor) (sql.NullTime, uuid.NullUUID) {
+	if cursor == nil {
+		return sql.NullTime{}, uuid.NullUUID{}
+	}
+	return sql.NullTime{Time: cursor.CreatedAt, Valid: true}, uuid.NullUUID{UUID: cursor.ID, Valid: true}
+}
+
+// setNextPageLink advertises the following page through an RFC 8288 Link
+// header, keeping every other query parameter of the current request.
+func setNextPageLink(w http.ResponseWriter, r *http.Request, next pageCursor) {
+	query := r.URL.Query()
+	query.Set("cursor", next.encode())
+	nextURL := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.String()))
+}
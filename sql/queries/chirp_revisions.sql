@@ -0,0 +1,14 @@
+-- name: CreateChirpRevision :exec
+INSERT INTO chirp_revisions (id, chirp_id, body, created_at, replaced_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ListChirpRevisions :many
+SELECT *
+FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY replaced_at DESC;
+
+-- name: DeleteChirpRevisions :exec
+DELETE
+FROM chirp_revisions
+WHERE chirp_id = $1;
@@ -1,17 +1,18 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, kind, rechirp_of)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 RETURNING *;
 
 -- name: GetChirps :many
 SELECT *
 FROM chirps
+WHERE deleted_at IS NULL
 ORDER BY created_at;
 
 -- name: GetChirpsByUserID :many
 SELECT *
 FROM chirps
-WHERE user_id = $1
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY created_at;
 
 -- name: GetChirpByID :one
@@ -19,7 +20,123 @@ SELECT *
 FROM chirps
 WHERE id = $1;
 
+-- name: LockChirp :one
+-- Holds the row until the transaction ends so concurrent edits of the same
+-- chirp are applied one after the other.
+SELECT *
+FROM chirps
+WHERE id = $1
+FOR UPDATE;
+
+-- name: ShareLockChirp :one
+-- Keeps the chirp from being deleted until the transaction ends, so a reply
+-- or rechirp created against it is seen by a concurrent delete.
+SELECT *
+FROM chirps
+WHERE id = $1
+FOR SHARE;
+
 -- name: DeleteChirpByID :exec
 DELETE
 FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: ListChirpsAsc :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: ListChirpsDesc :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ListTimelineChirps :many
+SELECT chirps.*
+FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = sqlc.arg('user_id')
+  AND chirps.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ListRepliesByParentIDs :many
+SELECT *
+FROM chirps
+WHERE in_reply_to = ANY(sqlc.arg('parent_ids')::uuid[])
+ORDER BY created_at ASC, id ASC;
+
+-- name: CountRepliesByChirpIDs :many
+SELECT in_reply_to, COUNT(*) AS reply_count
+FROM chirps
+WHERE in_reply_to = ANY(sqlc.arg('chirp_ids')::uuid[]) AND deleted_at IS NULL
+GROUP BY in_reply_to;
+
+-- name: ChirpIsReferenced :one
+SELECT EXISTS (
+    SELECT 1
+    FROM chirps
+    WHERE in_reply_to = sqlc.arg('id')::uuid OR rechirp_of = sqlc.arg('id')::uuid
+) AS is_referenced;
+
+-- name: ListChirpsByIDs :many
+SELECT *
+FROM chirps
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: UserHasRechirped :one
+SELECT EXISTS (
+    SELECT 1
+    FROM chirps
+    WHERE user_id = sqlc.arg('user_id')::uuid
+      AND rechirp_of = sqlc.arg('chirp_id')::uuid
+      AND kind = 'rechirp'
+      AND deleted_at IS NULL
+) AS has_rechirped;
+
+-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = $3
+WHERE id = $1
+RETURNING *;
+
+-- name: SearchChirps :many
+SELECT chirps.*, ts_rank(search_vector, to_tsquery('english', sqlc.arg('query')::text)) AS rank
+FROM chirps
+WHERE search_vector @@ to_tsquery('english', sqlc.arg('query')::text)
+  AND deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+  AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since')::timestamp)
+  AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until')::timestamp)
+  AND (sqlc.narg('cursor_rank')::real IS NULL
+       OR (ts_rank(search_vector, to_tsquery('english', sqlc.arg('query')::text)), created_at, id)
+          < (sqlc.narg('cursor_rank')::real, sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: CountChirpsByUserSince :one
+-- Tombstoned chirps still count, and chirps are tombstoned rather than
+-- deleted while they count, so deleting chirps doesn't free up room under
+-- the hourly limit. oldest_created_at is when the first counted chirp was
+-- posted, or since when there is none.
+SELECT COUNT(*) AS chirp_count,
+       COALESCE(MIN(created_at), sqlc.arg('since')::timestamp)::timestamp AS oldest_created_at
+FROM chirps
+WHERE user_id = sqlc.arg('user_id') AND created_at >= sqlc.arg('since')::timestamp;
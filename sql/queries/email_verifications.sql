@@ -0,0 +1,13 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (id, user_id, email, token_hash, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetEmailVerificationTokenByHash :one
+SELECT *
+FROM email_verification_tokens
+WHERE token_hash = $1;
+
+-- name: UseEmailVerificationToken :execrows
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL;
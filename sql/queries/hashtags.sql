@@ -0,0 +1,36 @@
+-- name: UpsertHashtag :one
+INSERT INTO hashtags (id, tag, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (tag) DO UPDATE SET tag = EXCLUDED.tag
+RETURNING *;
+
+-- name: CreateChirpHashtag :exec
+INSERT INTO chirp_hashtags (chirp_id, hashtag_id, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (chirp_id, hashtag_id) DO NOTHING;
+
+-- name: DeleteChirpHashtags :exec
+DELETE
+FROM chirp_hashtags
+WHERE chirp_id = $1;
+
+-- name: ListChirpsByHashtag :many
+SELECT chirps.*
+FROM chirps
+JOIN chirp_hashtags ON chirp_hashtags.chirp_id = chirps.id
+JOIN hashtags ON hashtags.id = chirp_hashtags.hashtag_id
+WHERE hashtags.tag = sqlc.arg('tag')
+  AND chirps.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ListTrendingHashtags :many
+SELECT hashtags.tag, COUNT(*) AS chirp_count
+FROM chirp_hashtags
+JOIN hashtags ON hashtags.id = chirp_hashtags.hashtag_id
+WHERE chirp_hashtags.created_at >= sqlc.arg('since')
+GROUP BY hashtags.tag
+ORDER BY chirp_count DESC, hashtags.tag ASC
+LIMIT sqlc.arg('limit');
@@ -0,0 +1,20 @@
+-- name: CreateLike :exec
+INSERT INTO likes (user_id, chirp_id, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: DeleteLike :exec
+DELETE
+FROM likes
+WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: CountLikesByChirpIDs :many
+SELECT chirp_id, COUNT(*) AS like_count
+FROM likes
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+GROUP BY chirp_id;
+
+-- name: ListLikedChirpIDs :many
+SELECT chirp_id
+FROM likes
+WHERE user_id = sqlc.arg('user_id') AND chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
@@ -0,0 +1,28 @@
+-- name: CreateChirpMention :exec
+INSERT INTO chirp_mentions (chirp_id, user_id, start_offset, end_offset, created_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: DeleteChirpMentions :exec
+DELETE
+FROM chirp_mentions
+WHERE chirp_id = $1;
+
+-- name: ListMentionsByChirpIDs :many
+SELECT chirp_mentions.chirp_id, chirp_mentions.user_id, chirp_mentions.start_offset, chirp_mentions.end_offset, users.handle
+FROM chirp_mentions
+JOIN users ON users.id = chirp_mentions.user_id
+WHERE chirp_mentions.chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_mentions.chirp_id, chirp_mentions.start_offset;
+
+-- name: ListChirpsMentioningUser :many
+SELECT chirps.*
+FROM chirps
+JOIN chirp_mentions ON chirp_mentions.chirp_id = chirps.id
+WHERE chirp_mentions.user_id = sqlc.arg('user_id')
+  AND chirps.user_id <> sqlc.arg('user_id')
+  AND chirps.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+GROUP BY chirps.id
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('limit');
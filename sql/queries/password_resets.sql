@@ -0,0 +1,21 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (id, user_id, token_hash, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetPasswordResetTokenByHash :one
+SELECT *
+FROM password_reset_tokens
+WHERE token_hash = $1;
+
+-- name: UsePasswordResetTokens :execrows
+-- Marks every outstanding reset token for the user as used, so an older
+-- email can't be used after a newer one. No rows are updated when the token
+-- being confirmed was already used.
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+    AND used_at IS NULL
+    AND EXISTS (
+        SELECT 1 FROM password_reset_tokens t
+        WHERE t.id = sqlc.arg('id') AND t.used_at IS NULL
+    );
@@ -1,14 +1,49 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
-VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, last_used_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 RETURNING *;
 
 -- name: GetRefreshToken :one
 SELECT *
 FROM refresh_tokens
-WHERE token = $1;
+WHERE token_hash = $1;
 
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET updated_at = NOW(), revoked_at = NOW()
-WHERE token = $1;
\ No newline at end of file
+WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET updated_at = sqlc.arg('revoked_at'), revoked_at = sqlc.arg('revoked_at'), replaced_by = sqlc.arg('replaced_by')
+WHERE token_hash = sqlc.arg('token_hash') AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: ListSessions :many
+-- Each family is one session; only its newest token is still unrevoked.
+SELECT
+    refresh_tokens.family_id,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = refresh_tokens.family_id)::TIMESTAMP AS created_at,
+    refresh_tokens.last_used_at,
+    refresh_tokens.expires_at,
+    refresh_tokens.user_agent,
+    refresh_tokens.ip_address
+FROM refresh_tokens
+WHERE refresh_tokens.user_id = $1
+    AND refresh_tokens.revoked_at IS NULL
+    AND refresh_tokens.expires_at > NOW()
+ORDER BY refresh_tokens.last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
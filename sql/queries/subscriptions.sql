@@ -0,0 +1,48 @@
+-- name: GetSubscription :one
+SELECT *
+FROM subscriptions
+WHERE user_id = $1;
+
+-- name: LockSubscription :one
+-- Holds the row until the transaction ends so events for the same user are
+-- applied one after the other.
+SELECT *
+FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: ActivateSubscription :one
+-- Starts or renews a subscription. A renewal never moves an active period
+-- back or shortens it, so a late or repeated event can't cut it short.
+-- The open-ended period of a legacy subscription is replaced by the one
+-- Polka sends.
+INSERT INTO subscriptions (user_id, plan, status, current_period_start, current_period_end, created_at, updated_at)
+VALUES ($1, $2, 'active', $3, $4, NOW(), NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan,
+    status = 'active',
+    current_period_start = GREATEST(
+        CASE WHEN subscriptions.status = 'active' THEN subscriptions.current_period_start END,
+        EXCLUDED.current_period_start
+    ),
+    current_period_end = CASE
+        WHEN subscriptions.legacy THEN EXCLUDED.current_period_end
+        ELSE GREATEST(
+            CASE WHEN subscriptions.status = 'active' THEN subscriptions.current_period_end END,
+            EXCLUDED.current_period_end
+        )
+    END,
+    legacy = FALSE,
+    ended_at = NULL,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: EndSubscription :exec
+UPDATE subscriptions
+SET status = sqlc.arg('status')::text, ended_at = sqlc.arg('ended_at')::timestamp, updated_at = NOW()
+WHERE user_id = sqlc.arg('user_id')::uuid AND status = 'active';
+
+-- name: ExpireSubscriptions :execrows
+UPDATE subscriptions
+SET status = 'expired', updated_at = NOW()
+WHERE status = 'active' AND current_period_end <= sqlc.arg('now')::timestamp;
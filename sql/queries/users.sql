@@ -1,6 +1,6 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING *;
 
 -- name: GetUserByEmail :one
@@ -8,17 +8,127 @@ SELECT *
 FROM users
 WHERE email = $1;
 
+-- name: GetUserByID :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: LockUser :exec
+-- Holds the user's row until the transaction ends so checks against what
+-- the user has already done, such as the hourly chirp limit, can't race.
+SELECT id
+FROM users
+WHERE id = $1
+FOR UPDATE;
+
 -- name: DeleteUsers :exec
 DELETE FROM users;
 
--- name: UpdateUser :one
+-- name: SetUserHandle :one
+UPDATE users
+SET handle = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUsersByHandles :many
+SELECT *
+FROM users
+WHERE LOWER(handle) = ANY(sqlc.arg('handles')::text[]);
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET display_name = $2, bio = $3, avatar_url = $4, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUserByHandle :one
+SELECT *
+FROM users
+WHERE LOWER(handle) = LOWER(sqlc.arg('handle')::text);
+
+-- name: GetUserProfileCounts :one
+SELECT
+    (SELECT COUNT(*) FROM chirps WHERE chirps.user_id = sqlc.arg('user_id')::uuid AND chirps.deleted_at IS NULL) AS chirp_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.followee_id = sqlc.arg('user_id')::uuid) AS follower_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.follower_id = sqlc.arg('user_id')::uuid) AS following_count;
+
+-- name: SetPendingTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled = FALSE, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET totp_enabled = TRUE, totp_last_step = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ListPlaintextTOTPSecrets :many
+-- Secrets stored before they were encrypted. Sealed ones start with "v1.".
+SELECT id, totp_secret::text AS totp_secret
+FROM users
+WHERE totp_secret IS NOT NULL AND totp_secret NOT LIKE 'v1.%';
+
+-- name: SealTOTPSecret :exec
+-- Replaces a plaintext secret with its sealed form. Does nothing when the
+-- secret changed since it was read.
+UPDATE users
+SET totp_secret = sqlc.arg('sealed_secret')::text
+WHERE id = sqlc.arg('id')::uuid AND totp_secret = sqlc.arg('plain_secret')::text;
+
+-- name: UseTOTPStep :execrows
+-- Records the step of an accepted code. No rows are updated when a code from
+-- that step or a later one was already used, which stops replays.
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetPendingEmail :one
+UPDATE users
+SET pending_email = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: MarkEmailVerified :one
+-- Matches nothing if the email changed after the verification was sent.
+UPDATE users
+SET email_verified_at = sqlc.arg('email_verified_at'), updated_at = NOW()
+WHERE id = sqlc.arg('id') AND email = sqlc.arg('email')
+RETURNING *;
+
+-- name: ConfirmPendingEmail :one
+-- Matches nothing if the pending email changed after the verification was
+-- sent.
 UPDATE users
-SET email = $2, hashed_password = $3
+SET email = pending_email, pending_email = NULL, email_verified_at = sqlc.arg('email_verified_at'), updated_at = NOW()
+WHERE id = sqlc.arg('id') AND pending_email = sqlc.arg('pending_email')::text
+RETURNING *;
+
+-- name: ScheduleUserDeletion :one
+UPDATE users
+SET delete_after = $2, updated_at = NOW()
 WHERE id = $1
 RETURNING *;
 
--- name: SetChirpyRed :one
+-- name: CancelUserDeletion :one
 UPDATE users
-SET is_chirpy_red = TRUE
+SET delete_after = NULL, updated_at = NOW()
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1;
+
+-- name: DeleteScheduledUsers :execrows
+DELETE FROM users
+WHERE delete_after <= sqlc.arg('now')::timestamp;
@@ -0,0 +1,28 @@
+-- name: CreateWebhookEvent :exec
+INSERT INTO webhook_events (id, event, payload, received_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: GetWebhookEvent :one
+SELECT *
+FROM webhook_events
+WHERE id = $1;
+
+-- name: LockWebhookEvent :one
+SELECT *
+FROM webhook_events
+WHERE id = $1
+FOR UPDATE;
+
+-- name: FinishWebhookEvent :one
+UPDATE webhook_events
+SET status = $2, last_error = $3, processed_at = $4, attempts = attempts + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: ListWebhookEvents :many
+SELECT *
+FROM webhook_events
+WHERE sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text
+ORDER BY received_at DESC
+LIMIT sqlc.arg('limit');
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX idx_chirps_in_reply_to ON chirps (in_reply_to, created_at, id);
+
+-- +goose Down
+DROP INDEX idx_chirps_in_reply_to;
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at,
+DROP COLUMN in_reply_to;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE likes (
+    user_id UUID NOT NULL,
+    chirp_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, chirp_id),
+    CONSTRAINT fk_user_likes FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_chirp_likes FOREIGN KEY (chirp_id) REFERENCES chirps(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_likes_chirp_id ON likes (chirp_id);
+
+-- +goose Down
+DROP TABLE likes;
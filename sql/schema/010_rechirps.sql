@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN kind TEXT NOT NULL DEFAULT 'chirp' CHECK (kind IN ('chirp', 'rechirp', 'quote')),
+ADD COLUMN rechirp_of UUID REFERENCES chirps(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_chirps_rechirp_of ON chirps (rechirp_of);
+CREATE UNIQUE INDEX idx_chirps_one_rechirp_per_user ON chirps (user_id, rechirp_of)
+WHERE kind = 'rechirp' AND deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX idx_chirps_one_rechirp_per_user;
+DROP INDEX idx_chirps_rechirp_of;
+
+ALTER TABLE chirps
+DROP COLUMN rechirp_of,
+DROP COLUMN kind;
@@ -0,0 +1,25 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN handle TEXT;
+
+CREATE UNIQUE INDEX idx_users_handle_lower ON users (LOWER(handle));
+
+CREATE TABLE chirp_mentions (
+    chirp_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (chirp_id, start_offset),
+    CONSTRAINT fk_chirp_mentions_chirp FOREIGN KEY (chirp_id) REFERENCES chirps(id) ON DELETE CASCADE,
+    CONSTRAINT fk_chirp_mentions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_chirp_mentions_user_id ON chirp_mentions (user_id, created_at, chirp_id);
+
+-- +goose Down
+DROP TABLE chirp_mentions;
+DROP INDEX idx_users_handle_lower;
+
+ALTER TABLE users
+DROP COLUMN handle;
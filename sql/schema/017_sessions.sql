@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+ADD COLUMN last_used_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+UPDATE refresh_tokens SET last_used_at = updated_at;
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX idx_refresh_tokens_user_id;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN last_used_at,
+DROP COLUMN ip_address,
+DROP COLUMN user_agent;
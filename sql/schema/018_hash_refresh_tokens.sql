@@ -0,0 +1,13 @@
+-- +goose Up
+-- Existing tokens are rehashed in place so nobody is logged out.
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex'),
+    replaced_by = encode(sha256(convert_to(replaced_by, 'UTF8')), 'hex');
+
+-- +goose Down
+-- Digests can't be turned back into tokens, so every session is dropped.
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
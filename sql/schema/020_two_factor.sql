@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN totp_secret TEXT,
+ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+-- +goose Down
+DROP TABLE recovery_codes;
+
+ALTER TABLE users
+DROP COLUMN totp_last_step,
+DROP COLUMN totp_enabled,
+DROP COLUMN totp_secret;
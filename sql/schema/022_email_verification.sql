@@ -0,0 +1,24 @@
+-- +goose Up
+-- Existing accounts start out unverified.
+ALTER TABLE users
+ADD COLUMN email_verified_at TIMESTAMP,
+ADD COLUMN pending_email TEXT;
+
+CREATE TABLE email_verification_tokens (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX idx_email_verification_tokens_user_id ON email_verification_tokens (user_id);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
+
+ALTER TABLE users
+DROP COLUMN pending_email,
+DROP COLUMN email_verified_at;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- delete_after is set while an account waits out the deletion grace period.
+-- Everything the user owns is removed through the ON DELETE CASCADE foreign
+-- keys once the row itself is deleted.
+ALTER TABLE users
+ADD COLUMN delete_after TIMESTAMP;
+
+CREATE INDEX idx_users_delete_after ON users (delete_after) WHERE delete_after IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_users_delete_after;
+
+ALTER TABLE users
+DROP COLUMN delete_after;
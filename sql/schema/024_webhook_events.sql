@@ -0,0 +1,18 @@
+-- +goose Up
+-- Every webhook Polka delivers, keyed by its event ID so that a redelivered
+-- event is only acted on once.
+CREATE TABLE webhook_events (
+    id TEXT PRIMARY KEY,
+    event TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    received_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_events_received_at ON webhook_events (received_at DESC);
+
+-- +goose Down
+DROP TABLE webhook_events;
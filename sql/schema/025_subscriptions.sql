@@ -0,0 +1,39 @@
+-- +goose Up
+-- A user is Chirpy Red while their subscription is active and the current
+-- period hasn't ended. This replaces the is_chirpy_red flag, which could
+-- only ever be turned on.
+CREATE TABLE subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_start TIMESTAMP NOT NULL,
+    current_period_end TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_subscriptions_active_period_end ON subscriptions (current_period_end) WHERE status = 'active';
+
+-- Nothing recorded when existing upgrades run out, so give them one billing
+-- period from now; Polka's next renewal extends it.
+INSERT INTO subscriptions (user_id, plan, status, current_period_start, current_period_end, created_at, updated_at)
+SELECT id, 'chirpy_red', 'active', NOW(), NOW() + INTERVAL '30 days', NOW(), NOW()
+FROM users
+WHERE is_chirpy_red;
+
+ALTER TABLE users
+DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users
+ADD COLUMN is_chirpy_red BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users
+SET is_chirpy_red = TRUE
+WHERE id IN (
+    SELECT user_id
+    FROM subscriptions
+    WHERE status = 'active' AND current_period_end > NOW()
+);
+
+DROP TABLE subscriptions;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- When Polka said the subscription was cancelled or expired, so that an
+-- upgrade or renewal from before then that arrives late doesn't turn it
+-- back on. Left NULL when the period simply ran out.
+ALTER TABLE subscriptions
+ADD COLUMN ended_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE subscriptions
+DROP COLUMN ended_at;
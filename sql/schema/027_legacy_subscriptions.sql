@@ -0,0 +1,25 @@
+-- +goose Up
+-- 025_subscriptions.sql gave users who had is_chirpy_red a 30 day period,
+-- but Polka never sent renewals for them, so they would lose Chirpy Red for
+-- good once it ran out. Keep them subscribed until Polka sends an event that
+-- says otherwise. Their rows are the ones that migration made: created in
+-- the same statement that started their period, with exactly 30 days in it.
+ALTER TABLE subscriptions
+ADD COLUMN legacy BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE subscriptions
+SET legacy = TRUE,
+    status = 'active',
+    current_period_end = '9999-12-31',
+    updated_at = NOW()
+WHERE created_at = current_period_start
+  AND current_period_end = current_period_start + INTERVAL '30 days'
+  AND status IN ('active', 'expired');
+
+-- +goose Down
+UPDATE subscriptions
+SET current_period_end = current_period_start + INTERVAL '30 days'
+WHERE legacy;
+
+ALTER TABLE subscriptions
+DROP COLUMN legacy;
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trungdoanle1101/chirp/internal/database"
+	"github.com/trungdoanle1101/chirp/internal/entitlements"
+)
+
+// Values of subscriptions.status.
+const (
+	subscriptionStatusActive   = "active"
+	subscriptionStatusCanceled = "canceled"
+	subscriptionStatusExpired  = "expired"
+)
+
+// chirpyRedBillingPeriod is assumed when Polka doesn't say when a period
+// ends.
+const chirpyRedBillingPeriod = 30 * 24 * time.Hour
+
+// subscriptionActive reports whether sub grants its plan at now. The period
+// end is checked as well as the status so that a subscription stops counting
+// the moment it runs out, not when the next sweep gets to it.
+func subscriptionActive(sub database.Subscription, now time.Time) bool {
+	return sub.Status == subscriptionStatusActive && sub.CurrentPeriodEnd.After(now)
+}
+
+// activePlan returns the plan of userID's active subscription, or the free
+// plan when there is none.
+func (cfg *apiConfig) activePlan(ctx context.Context, userID uuid.UUID) (entitlements.Plan, error) {
+	sub, err := cfg.db.GetSubscription(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entitlements.PlanFree, nil
+		}
+		return "", err
+	}
+	if !subscriptionActive(sub, time.Now().UTC()) {
+		return entitlements.PlanFree, nil
+	}
+	return entitlements.Plan(sub.Plan), nil
+}
+
+// isChirpyRed reports whether userID has an active Chirpy Red subscription.
+func (cfg *apiConfig) isChirpyRed(ctx context.Context, userID uuid.UUID) (bool, error) {
+	plan, err := cfg.activePlan(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return plan == entitlements.PlanChirpyRed, nil
+}
+
+// entitlementsFor returns what userID's current plan lets them do.
+func (cfg *apiConfig) entitlementsFor(ctx context.Context, userID uuid.UUID) (entitlements.Entitlements, error) {
+	plan, err := cfg.activePlan(ctx, userID)
+	if err != nil {
+		return entitlements.Entitlements{}, err
+	}
+	return entitlements.For(plan), nil
+}
+
+// expireSubscriptions marks subscriptions whose period ended without a
+// renewal as expired.
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context) {
+	expired, err := cfg.db.ExpireSubscriptions(ctx, time.Now().UTC())
+	if err != nil {
+		log.Printf("Couldn't expire subscriptions: %s", err)
+		return
+	}
+	if expired > 0 {
+		log.Printf("Expired %d subscriptions", expired)
+	}
+}
+
+// runEvery calls task right away and then once per interval until ctx is
+// done.
+func runEvery(ctx context.Context, interval time.Duration, task func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		task(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}